@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lanRanges are the private (RFC 1918 and RFC 4193), link-local and loopback
+// ranges allowed by --lan-only.
+var lanRanges = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"127.0.0.0/8",
+	"fc00::/7",
+	"fe80::/10",
+	"::1/128",
+}
+
+// accessList filters the clients by their address. Denied ranges win over
+// allowed ones, and every client is allowed if no range is.
+type accessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newAccessList returns the access list allowing the allow ranges, and the
+// local ones if lanOnly is set, and denying the deny ranges. Ranges are in
+// the CIDR notation, or single addresses.
+func newAccessList(allow, deny []string, lanOnly bool) (*accessList, error) {
+	if lanOnly {
+		allow = append(allow, lanRanges...)
+	}
+
+	var err error
+	a := &accessList{}
+	if a.allow, err = parseRanges(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseRanges(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// parseRanges parses CIDR ranges. A single address is a range of its own.
+func parseRanges(ranges []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if !strings.Contains(r, "/") {
+			ip := net.ParseIP(r)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %s", r)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %s", r)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// permits reports whether ip may be served.
+func (a *accessList) permits(ip net.IP) bool {
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a handler serving h to the permitted clients only, and
+// answering 403 Forbidden to the others.
+func (a *accessList) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		// Link-local clients have the zone of their interface in their
+		// address, like fe80::1%eth0
+		ip, err := netip.ParseAddr(host)
+		if err != nil || !a.permits(net.IP(ip.WithZone("").AsSlice())) {
+			logrus.Warnf("Forbidden request for %s from %s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_accessList_permits(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		lanOnly bool
+		ip      string
+		want    bool
+	}{
+		{name: "no rule", ip: "203.0.113.7", want: true},
+		{name: "allowed range", allow: []string{"192.168.1.0/24"}, ip: "192.168.1.12", want: true},
+		{name: "outside allowed range", allow: []string{"192.168.1.0/24"}, ip: "192.168.2.12", want: false},
+		{name: "denied range", deny: []string{"10.0.0.0/8"}, ip: "10.1.2.3", want: false},
+		{name: "deny wins", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.5"}, ip: "10.0.0.5", want: false},
+		{name: "single address", allow: []string{"10.0.0.5"}, ip: "10.0.0.5", want: true},
+		{name: "lan only private", lanOnly: true, ip: "172.20.0.1", want: true},
+		{name: "lan only link-local v6", lanOnly: true, ip: "fe80::1", want: true},
+		{name: "lan only public", lanOnly: true, ip: "8.8.8.8", want: false},
+		{name: "mapped v4", allow: []string{"192.168.1.0/24"}, ip: "::ffff:192.168.1.12", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAccessList(tt.allow, tt.deny, tt.lanOnly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.permits(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("permits(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		wantErr bool
+	}{
+		{name: "cidr", in: []string{"192.168.0.0/16", "fd00::/8"}},
+		{name: "addresses", in: []string{"10.0.0.1", "::1"}},
+		{name: "invalid address", in: []string{"10.0.0"}, wantErr: true},
+		{name: "invalid mask", in: []string{"10.0.0.0/33"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseRanges(tt.in); (err != nil) != tt.wantErr {
+				t.Errorf("parseRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_accessList_wrap(t *testing.T) {
+	a, err := newAccessList(nil, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name string
+		addr string
+		want int
+	}{
+		{name: "private", addr: "192.168.1.12:4242", want: http.StatusOK},
+		{name: "link-local with zone", addr: "[fe80::1%eth0]:4242", want: http.StatusOK},
+		{name: "public", addr: "8.8.8.8:4242", want: http.StatusForbidden},
+		{name: "public v6 with zone", addr: "[2001:db8::1%eth0]:4242", want: http.StatusForbidden},
+		{name: "invalid", addr: "nowhere", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.addr
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// hostAddress is an address clients can reach a server at.
+type hostAddress struct {
+	addr string
+	// iface is the name of the interface holding the address, if known.
+	iface string
+	// primary marks the address of the default route, the one the clients of
+	// the local network most likely reach.
+	primary bool
+}
+
+// url returns the URL of path on a server listening on port at a.
+func (a hostAddress) url(scheme, port, path string) string {
+	return scheme + "://" + net.JoinHostPort(a.addr, port) + path
+}
+
+// printURLs prints that what is served at path, with its URL at each one of
+// hosts. The interfaces and the primary address are given when there are
+// several of them.
+func printURLs(w io.Writer, what string, hosts []hostAddress, scheme, port, path string) {
+	if len(hosts) == 1 {
+		fmt.Fprintf(w, "%s on %s\n", what, hosts[0].url(scheme, port, path))
+		return
+	}
+
+	fmt.Fprintf(w, "%s on:\n", what)
+	for _, h := range hosts {
+		var notes []string
+		if h.iface != "" {
+			notes = append(notes, h.iface)
+		}
+		if h.primary {
+			notes = append(notes, "primary")
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(w, "  %s  (%s)\n", h.url(scheme, port, path), strings.Join(notes, ", "))
+		} else {
+			fmt.Fprintf(w, "  %s\n", h.url(scheme, port, path))
+		}
+	}
+}
+
+// serverAddresses returns the addresses clients can reach a server listening
+// on ip at: ip itself, or the addresses of the interfaces that are up if it
+// listens on all of them.
+func serverAddresses(ip string) []hostAddress {
+	if parsed := net.ParseIP(ip); ip != "" && (parsed == nil || !parsed.IsUnspecified()) {
+		return []hostAddress{{addr: ip, primary: true}}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return []hostAddress{{addr: ip}}
+	}
+	var all []hostAddress
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				all = append(all, hostAddress{addr: n.IP.String(), iface: iface.Name})
+			}
+		}
+	}
+
+	usable := usableAddresses(all, primaryAddress())
+	if len(usable) == 0 {
+		return []hostAddress{{addr: ip}}
+	}
+	return usable
+}
+
+// usableAddresses filters addrs down to the ones that can be put in a URL,
+// and marks primary as such. Loopback addresses are only kept if there is no
+// other one, and IPv6 link-local ones never, since they need a zone. Without
+// primary, the first private IPv4 address is marked instead. The primary
+// address comes first, then the IPv4 ones.
+func usableAddresses(addrs []hostAddress, primary net.IP) []hostAddress {
+	var usable, loopback []hostAddress
+	for _, a := range addrs {
+		ip := net.ParseIP(a.addr)
+		switch {
+		case ip == nil || ip.IsUnspecified() || ip.IsMulticast():
+		case ip.IsLoopback():
+			loopback = append(loopback, a)
+		case ip.To4() == nil && ip.IsLinkLocalUnicast():
+		default:
+			a.primary = primary != nil && ip.Equal(primary)
+			usable = append(usable, a)
+		}
+	}
+	if len(usable) == 0 {
+		return loopback
+	}
+
+	marked := false
+	for _, a := range usable {
+		marked = marked || a.primary
+	}
+	for i := range usable {
+		if ip := net.ParseIP(usable[i].addr); !marked && ip.To4() != nil && ip.IsPrivate() {
+			usable[i].primary = true
+			break
+		}
+	}
+
+	sort.SliceStable(usable, func(i, j int) bool {
+		if usable[i].primary != usable[j].primary {
+			return usable[i].primary
+		}
+		return net.ParseIP(usable[i].addr).To4() != nil && net.ParseIP(usable[j].addr).To4() == nil
+	})
+	return usable
+}
+
+// primaryAddress returns the local address of the default route, IPv4 first,
+// or nil if there is none.
+func primaryAddress() net.IP {
+	// Connecting a UDP socket sends nothing, it only picks the route
+	for _, target := range []string{"192.0.2.1:9", "[2001:db8::1]:9"} {
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		conn.Close()
+		if ok && !addr.IP.IsUnspecified() {
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+// interfaceIP returns the address to serve on to be reachable on the
+// interface called name: its first IPv4 address, or else its first IPv6
+// address that is not link-local.
+func interfaceIP(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "", fmt.Errorf("interface %s is down", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+
+	var ipv6 string
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		switch {
+		case !ok:
+		case n.IP.To4() != nil:
+			return n.IP.String(), nil
+		case ipv6 == "" && !n.IP.IsLinkLocalUnicast():
+			ipv6 = n.IP.String()
+		}
+	}
+	if ipv6 == "" {
+		return "", fmt.Errorf("interface %s has no usable address", name)
+	}
+	return ipv6, nil
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func Test_usableAddresses(t *testing.T) {
+	lo := hostAddress{addr: "127.0.0.1", iface: "lo"}
+	lo6 := hostAddress{addr: "::1", iface: "lo"}
+	lan := hostAddress{addr: "192.168.1.36", iface: "eth0"}
+	lan6 := hostAddress{addr: "2a01:cb00::36", iface: "eth0"}
+	linkLocal6 := hostAddress{addr: "fe80::1", iface: "eth0"}
+	vpn := hostAddress{addr: "10.8.0.2", iface: "tun0"}
+	public := hostAddress{addr: "203.0.113.7", iface: "ppp0"}
+	primary := func(a hostAddress) hostAddress {
+		a.primary = true
+		return a
+	}
+
+	tests := []struct {
+		name    string
+		addrs   []hostAddress
+		primary net.IP
+		want    []hostAddress
+	}{
+		{
+			name:    "default route",
+			addrs:   []hostAddress{lo, lo6, lan6, linkLocal6, vpn, lan},
+			primary: net.ParseIP("192.168.1.36"),
+			want:    []hostAddress{primary(lan), vpn, lan6},
+		},
+		{
+			name:  "no default route",
+			addrs: []hostAddress{lo, public, lan6, vpn, lan},
+			want:  []hostAddress{primary(vpn), public, lan, lan6},
+		},
+		{
+			name:  "loopback only",
+			addrs: []hostAddress{lo, lo6},
+			want:  []hostAddress{lo, lo6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usableAddresses(tt.addrs, tt.primary); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("usableAddresses() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printURLs(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts []hostAddress
+		want  string
+	}{
+		{
+			name:  "single",
+			hosts: []hostAddress{{addr: "192.168.1.36", primary: true}},
+			want:  "Sharing notes.txt on http://192.168.1.36:8080/notes.txt\n",
+		},
+		{
+			name:  "several",
+			hosts: []hostAddress{{addr: "192.168.1.36", iface: "eth0", primary: true}, {addr: "2a01:cb00::36", iface: "eth0"}},
+			want:  "Sharing notes.txt on:\n  http://192.168.1.36:8080/notes.txt  (eth0, primary)\n  http://[2a01:cb00::36]:8080/notes.txt  (eth0)\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printURLs(&buf, "Sharing notes.txt", tt.hosts, "http", "8080", "/notes.txt")
+			if buf.String() != tt.want {
+				t.Errorf("printURLs() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_serverAddresses(t *testing.T) {
+	for _, ip := range []string{"192.168.1.36", "::1", "localhost"} {
+		if got := serverAddresses(ip); len(got) != 1 || got[0].addr != ip {
+			t.Errorf("serverAddresses(%s) = %+v", ip, got)
+		}
+	}
+	for _, a := range serverAddresses("0.0.0.0") {
+		if ip := net.ParseIP(a.addr); ip != nil && ip.IsUnspecified() {
+			t.Errorf("serverAddresses(0.0.0.0) returned %s", a.addr)
+		}
+	}
+}
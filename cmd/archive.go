@@ -0,0 +1,579 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// archiveExtensions maps the archive formats a folder can be shared as to the
+// extension of their file name.
+var archiveExtensions = map[string]string{
+	"zip":  ".zip",
+	"tar":  ".tar",
+	"tgz":  ".tar.gz",
+	"tzst": ".tar.zst",
+}
+
+// archiveFormats returns the names of the supported archive formats.
+func archiveFormats() []string {
+	var formats []string
+	for f := range archiveExtensions {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// writeArchive writes source in w as an archive of the given format.
+func writeArchive(w io.Writer, source, format string) error {
+	switch format {
+	case "zip":
+		return writeZip(w, source)
+	case "tar":
+		return writeTar(w, source)
+	case "tgz":
+		gw := gzip.NewWriter(w)
+		if err := writeTar(gw, source); err != nil {
+			return err
+		}
+		return gw.Close()
+	case "tzst":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if err := writeTar(zw, source); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	default:
+		return fmt.Errorf("unknown archive format %q, use one of %s", format, strings.Join(archiveFormats(), ", "))
+	}
+}
+
+// writeZip compresses recursively source into w. Symbolic links are stored as
+// such, they are never followed.
+func writeZip(w io.Writer, source string) error {
+	archive := zip.NewWriter(w)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	var baseDir string
+	if info.IsDir() {
+		baseDir = filepath.Base(source)
+	}
+
+	if err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !archivable(info) {
+			logrus.Warnf("Skipping %s: unsupported file type", path)
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		if baseDir != "" {
+			header.Name = filepath.ToSlash(filepath.Join(baseDir, strings.TrimPrefix(path, source)))
+		}
+
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		// The content of a symbolic link is its target
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(writer, target)
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeTar archives recursively source into w. Unlike zip, tar keeps the
+// permissions, the ownership and the symbolic links of the files.
+func writeTar(w io.Writer, source string) error {
+	archive := tar.NewWriter(w)
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	var baseDir string
+	if info.IsDir() {
+		baseDir = filepath.Base(source)
+	}
+
+	if err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !archivable(info) {
+			logrus.Warnf("Skipping %s: unsupported file type", path)
+			return nil
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		if baseDir != "" {
+			header.Name = filepath.ToSlash(filepath.Join(baseDir, strings.TrimPrefix(path, source)))
+		}
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(archive, file)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// archivable reports whether the file described by info can be archived: it
+// must be a folder, a regular file or a symbolic link, and not a socket, a
+// named pipe or a device.
+func archivable(info os.FileInfo) bool {
+	return info.IsDir() || info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0
+}
+
+// Magic numbers of the supported archive and compression formats.
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	emptyZipMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic in a tar header.
+const tarMagicOffset = 257
+
+// detectArchiveFormat returns the archive format of a file starting with
+// header. Compressed files are assumed to contain a tar archive.
+func detectArchiveFormat(header []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, emptyZipMagic):
+		return "zip", true
+	case bytes.HasPrefix(header, gzipMagic):
+		return "tgz", true
+	case bytes.HasPrefix(header, zstdMagic):
+		return "tzst", true
+	case len(header) >= tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic):
+		return "tar", true
+	}
+	return "", false
+}
+
+// archiveFormatOf returns the archive format of name, guessed from its
+// extension.
+func archiveFormatOf(name string) (string, error) {
+	for format, ext := range archiveExtensions {
+		if strings.HasSuffix(name, ext) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not a supported archive", name)
+}
+
+// extractArchive extracts the archive name in the folder dest, which is
+// created if needed. The format is detected from the first bytes of the file,
+// or from its extension for the old tar archives without magic. If permissions
+// is set, the modes and the modification times of the files are restored.
+func extractArchive(name, dest string, permissions bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	format, ok := detectArchiveFormat(header[:n])
+	if !ok {
+		if format, err = archiveFormatOf(name); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dest, 0777); err != nil {
+		return err
+	}
+	x, err := newExtractor(dest, permissions)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "zip":
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			err = x.extractZip(f, fi.Size())
+		}
+	case "tgz":
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(f); err == nil {
+			err = x.extractTar(gr)
+			gr.Close()
+		}
+	case "tzst":
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(f); err == nil {
+			err = x.extractTar(zr)
+			zr.Close()
+		}
+	default:
+		err = x.extractTar(f)
+	}
+	if err != nil {
+		return err
+	}
+	return x.finish()
+}
+
+// extractor writes the members of an archive in a destination folder, making
+// sure that nothing is ever written outside of it: absolute paths, paths going
+// up with .. and paths going through symbolic links that lead outside of the
+// destination are rejected.
+type extractor struct {
+	dest        string
+	permissions bool
+
+	// dirs holds the modes of the extracted folders. They are applied once
+	// everything is extracted, as a read-only folder could not be filled.
+	dirs map[string]os.FileMode
+}
+
+func newExtractor(dest string, permissions bool) (*extractor, error) {
+	resolved, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{dest: resolved, permissions: permissions, dirs: map[string]os.FileMode{}}, nil
+}
+
+// inside reports whether the absolute path name is inside the destination.
+func (x *extractor) inside(name string) bool {
+	return name == x.dest || strings.HasPrefix(name, x.dest+string(filepath.Separator))
+}
+
+// path returns where the archive member name must be extracted, after
+// checking that it stays inside the destination.
+func (x *extractor) path(name string) (string, error) {
+	if path.IsAbs(name) || strings.HasPrefix(name, "\\") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("refusing to extract %s: absolute path", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("refusing to extract %s: outside of the destination", name)
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(clean))
+
+	// A previously extracted symbolic link could make the parent folder point
+	// anywhere, so resolve it.
+	parent, err := x.resolveParent(filepath.Dir(target))
+	if err != nil {
+		return "", err
+	}
+	if !x.inside(parent) {
+		return "", fmt.Errorf("refusing to extract %s: outside of the destination", name)
+	}
+	return filepath.Join(parent, filepath.Base(target)), nil
+}
+
+// resolveParent follows the symbolic links of dir, whose deepest parts may
+// not exist yet.
+func (x *extractor) resolveParent(dir string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) || dir == x.dest {
+		return "", err
+	}
+
+	parent, err := x.resolveParent(filepath.Dir(dir))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(dir)), nil
+}
+
+// removeLink removes target if it is a symbolic link, so that a file is never
+// written through it.
+func (x *extractor) removeLink(target string) error {
+	fi, err := os.Lstat(target)
+	if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(target)
+	}
+	return nil
+}
+
+func (x *extractor) dir(name string, mode os.FileMode) error {
+	target, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := x.removeLink(target); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, 0777); err != nil {
+		return err
+	}
+	if x.permissions {
+		x.dirs[target] = mode.Perm()
+	}
+	return nil
+}
+
+func (x *extractor) file(name string, r io.Reader, mode os.FileMode, modTime time.Time) error {
+	target, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	if err := x.removeLink(target); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if !x.permissions {
+		return nil
+	}
+	if err := os.Chmod(target, mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// symlink creates a symbolic link, as long as what it points to is inside the
+// destination.
+func (x *extractor) symlink(name, linkname string) error {
+	target, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if filepath.IsAbs(linkname) || path.IsAbs(linkname) {
+		return fmt.Errorf("refusing to extract %s: link to absolute path %s", name, linkname)
+	}
+	// Whether a .. leaves the destination depends on the links it goes
+	// through, which later entries can replace, so links can only go down.
+	for _, part := range strings.FieldsFunc(linkname, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("refusing to extract %s: link to %s goes up", name, linkname)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(linkname, target)
+}
+
+// link creates a hard link to linkname, an already extracted member.
+func (x *extractor) link(name, linkname string) error {
+	target, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	source, err := x.path(linkname)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(source, target)
+}
+
+// finish applies the modes of the extracted folders, deepest first.
+func (x *extractor) finish() error {
+	var dirs []string
+	for d := range x.dirs {
+		dirs = append(dirs, d)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+
+	for _, d := range dirs {
+		if err := os.Chmod(d, x.dirs[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZip extracts the zip archive read from r.
+func (x *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.dir(zf.Name, mode)
+		case mode&os.ModeSymlink != 0:
+			err = x.extractZipLink(zf)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = zf.Open(); err == nil {
+				err = x.file(zf.Name, rc, mode, zf.Modified)
+				rc.Close()
+			}
+		default:
+			logrus.Warnf("Skipping %s: unsupported file type", zf.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZipLink extracts a symbolic link stored in a zip archive, whose
+// content is the target of the link.
+func (x *extractor) extractZipLink(zf *zip.File) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	linkname, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(zf.Name, string(linkname))
+}
+
+// extractTar extracts the tar archive read from r.
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(header.Name, mode)
+		case tar.TypeReg:
+			err = x.file(header.Name, tr, mode, header.ModTime)
+		case tar.TypeSymlink:
+			err = x.symlink(header.Name, header.Linkname)
+		case tar.TypeLink:
+			err = x.link(header.Name, header.Linkname)
+		default:
+			logrus.Warnf("Skipping %s: unsupported file type", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_detectArchiveFormat(t *testing.T) {
+	var tarHeader bytes.Buffer
+	tw := tar.NewWriter(&tarHeader)
+	if err := tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Format: tar.FormatUSTAR}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+		wantOk bool
+	}{
+		{name: "zip", header: []byte("PK\x03\x04rest"), want: "zip", wantOk: true},
+		{name: "empty zip", header: []byte("PK\x05\x06"), want: "zip", wantOk: true},
+		{name: "gzip", header: []byte{0x1f, 0x8b, 0x08}, want: "tgz", wantOk: true},
+		{name: "zstd", header: []byte{0x28, 0xb5, 0x2f, 0xfd}, want: "tzst", wantOk: true},
+		{name: "tar", header: tarHeader.Bytes(), want: "tar", wantOk: true},
+		{name: "text", header: []byte("hello"), wantOk: false},
+		{name: "empty", header: nil, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := detectArchiveFormat(tt.header)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("detectArchiveFormat() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_extractor_extractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []tar.Header
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "files",
+			headers: []tar.Header{{Name: "d/", Typeflag: tar.TypeDir}, {Name: "d/f", Typeflag: tar.TypeReg}},
+			want:    []string{"d/f"},
+		},
+		{
+			name:    "dot dot",
+			headers: []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "dot dot inside",
+			headers: []tar.Header{{Name: "d/../../evil", Typeflag: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute",
+			headers: []tar.Header{{Name: "/tmp/evil", Typeflag: tar.TypeReg}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute link",
+			headers: []tar.Header{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+			wantErr: true,
+		},
+		{
+			name:    "link outside",
+			headers: []tar.Header{{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+			wantErr: true,
+		},
+		{
+			name: "links through links",
+			headers: []tar.Header{
+				{Name: "s2", Typeflag: tar.TypeSymlink, Linkname: "."},
+				{Name: "s1", Typeflag: tar.TypeSymlink, Linkname: "s2/.."},
+			},
+			wantErr: true,
+		},
+		{
+			name: "link to a replaced folder",
+			headers: []tar.Header{
+				{Name: "d/", Typeflag: tar.TypeDir},
+				{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "d/.."},
+				{Name: "d", Typeflag: tar.TypeSymlink, Linkname: "."},
+			},
+			wantErr: true,
+		},
+		{
+			name: "link inside",
+			headers: []tar.Header{
+				{Name: "d/f", Typeflag: tar.TypeReg},
+				{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "d"},
+				{Name: "l/g", Typeflag: tar.TypeReg},
+			},
+			want: []string{"d/f", "d/g"},
+		},
+		{
+			name:    "hard link outside",
+			headers: []tar.Header{{Name: "h", Typeflag: tar.TypeLink, Linkname: "../outside/secret"}},
+			wantErr: true,
+		},
+		{
+			name: "hard link inside",
+			headers: []tar.Header{
+				{Name: "f", Typeflag: tar.TypeReg},
+				{Name: "h", Typeflag: tar.TypeLink, Linkname: "f"},
+			},
+			want: []string{"f", "h"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "shaloc-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			dest := filepath.Join(dir, "dest")
+			for _, d := range []string{dest, filepath.Join(dir, "outside")} {
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "outside", "secret"), []byte("plop"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var archive bytes.Buffer
+			tw := tar.NewWriter(&archive)
+			for _, h := range tt.headers {
+				h.Mode = 0644
+				if err := tw.WriteHeader(&h); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tw.Close()
+
+			x, err := newExtractor(dest, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = x.extractTar(&archive)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, f := range tt.want {
+				if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(f))); err != nil {
+					t.Errorf("extractTar() did not create %s: %s", f, err)
+				}
+			}
+			for _, f := range []string{filepath.Join(dir, "evil"), "/tmp/evil"} {
+				if _, err := os.Lstat(f); err == nil {
+					t.Errorf("extractTar() created %s", f)
+				}
+			}
+		})
+	}
+}
+
+func Test_writeArchive_socket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "folder")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(source, "f"), []byte("plop"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", filepath.Join(source, "sock"))
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+
+	// Sockets are skipped, the rest of the folder is archived
+	for _, format := range archiveFormats() {
+		t.Run(format, func(t *testing.T) {
+			if err := writeArchive(ioutil.Discard, source, format); err != nil {
+				t.Errorf("writeArchive() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_writeArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "folder")
+	if err := os.MkdirAll(filepath.Join(source, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+	}{
+		{name: "a.txt", content: "plop", mode: 0640},
+		{name: "sub/run.sh", content: "#!/bin/sh", mode: 0755},
+	}
+	for _, f := range files {
+		p := filepath.Join(source, f.name)
+		if err := ioutil.WriteFile(p, []byte(f.content), f.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(source, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/run.sh", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every format is read back with the same content, modes and links
+	for _, format := range archiveFormats() {
+		t.Run(format, func(t *testing.T) {
+			archive := filepath.Join(dir, "folder"+archiveExtensions[format])
+			f, err := os.Create(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeArchive(f, source, format); err != nil {
+				t.Fatalf("writeArchive() error = %v", err)
+			}
+			f.Close()
+
+			dest := filepath.Join(dir, "dest-"+format)
+			if err := extractArchive(archive, dest, true); err != nil {
+				t.Fatalf("extractArchive() error = %v", err)
+			}
+			for _, f := range files {
+				p := filepath.Join(dest, "folder", f.name)
+				got, err := ioutil.ReadFile(p)
+				if err != nil || string(got) != f.content {
+					t.Errorf("%s = %q, %v, want %q", f.name, got, err, f.content)
+				}
+				if fi, err := os.Stat(p); err == nil && fi.Mode().Perm() != f.mode {
+					t.Errorf("%s mode = %v, want %v", f.name, fi.Mode().Perm(), f.mode)
+				}
+			}
+			if fi, err := os.Stat(filepath.Join(dest, "folder", "sub")); err != nil || fi.Mode().Perm() != 0750 {
+				t.Errorf("sub = %v, %v, want mode %v", fi, err, os.FileMode(0750))
+			}
+			if got, err := os.Readlink(filepath.Join(dest, "folder", "link")); err != nil || got != "sub/run.sh" {
+				t.Errorf("link = %q, %v, want %q", got, err, "sub/run.sh")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tokenParam is the query parameter carrying the token in shared URLs.
+	tokenParam = "token"
+	// tokenCookie keeps a browser authenticated once it opened a URL with
+	// the token, so that the links of the pages work without it.
+	tokenCookie = "shaloc_token"
+	// authFailureWindow is the period over which failed attempts are
+	// counted, and for which a client is blocked once it exceeds the limit.
+	authFailureWindow = time.Minute
+)
+
+// authenticator only lets through the requests carrying the token, or the
+// credentials of the basic authentication. Failed attempts are logged, and
+// with a limit, clients failing too often are blocked for a while.
+type authenticator struct {
+	token string
+	user  string
+	pass  string
+	limit int
+
+	mu       sync.Mutex
+	failures map[string]*authFailures
+}
+
+type authFailures struct {
+	count int
+	since time.Time
+}
+
+// newAuthenticator returns an authenticator accepting token and basicAuth, in
+// the user:pass form, if they are not empty. It blocks the clients failing
+// more than limit times in a minute, if limit is positive.
+func newAuthenticator(token, basicAuth string, limit int) (*authenticator, error) {
+	a := &authenticator{token: token, limit: limit, failures: map[string]*authFailures{}}
+	if basicAuth != "" {
+		var err error
+		if a.user, a.pass, err = parseCredentials(basicAuth); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// newToken returns a random token.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// parseCredentials splits credentials in the user:pass form.
+func parseCredentials(s string) (string, string, error) {
+	user, pass, ok := strings.Cut(s, ":")
+	if !ok || user == "" || pass == "" {
+		return "", "", fmt.Errorf("invalid credentials %q, expected user:pass", s)
+	}
+	return user, pass, nil
+}
+
+// wrap returns a handler serving h to the authenticated requests only.
+func (a *authenticator) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if a.blocked(client) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(authFailureWindow.Seconds())))
+			http.Error(w, "too many failed attempts", http.StatusTooManyRequests)
+			return
+		}
+
+		if !a.authorized(w, r) {
+			logrus.Warnf("Unauthorized request for %s from %s", r.URL.Path, r.RemoteAddr)
+			a.fail(client)
+			if a.user != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="shaloc", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether r carries valid credentials. A browser opening
+// a URL with the token gets a cookie carrying it.
+func (a *authenticator) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if a.token != "" {
+		if equal(r.URL.Query().Get(tokenParam), a.token) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookie,
+				Value:    a.token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			return true
+		}
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && equal(bearer, a.token) {
+			return true
+		}
+		if c, err := r.Cookie(tokenCookie); err == nil && equal(c.Value, a.token) {
+			return true
+		}
+	}
+	if a.user != "" {
+		if user, pass, ok := r.BasicAuth(); ok && equal(user, a.user) && equal(pass, a.pass) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares secrets in constant time.
+func equal(got, want string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// fail counts a failed attempt of client.
+func (a *authenticator) fail(client string) {
+	if a.limit <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.failures[client]
+	if !ok || time.Since(f.since) > authFailureWindow {
+		f = &authFailures{since: time.Now()}
+		a.failures[client] = f
+	}
+	f.count++
+	if f.count == a.limit {
+		logrus.Warnf("Too many failed attempts from %s, blocking it for %s", client, authFailureWindow)
+		f.since = time.Now()
+	}
+}
+
+// blocked reports whether client failed too many times recently.
+func (a *authenticator) blocked(client string) bool {
+	if a.limit <= 0 {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, ok := a.failures[client]
+	if !ok {
+		return false
+	}
+	if time.Since(f.since) > authFailureWindow {
+		delete(a.failures, client)
+		return false
+	}
+	return f.count >= a.limit
+}
+
+// credentialsTransport adds a bearer token, or basic authentication
+// credentials, to the requests sent by base.
+type credentialsTransport struct {
+	base  http.RoundTripper
+	token string
+	user  string
+	pass  string
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	} else if t.user != "" {
+		req.SetBasicAuth(t.user, t.pass)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withCredentials returns a client sending the requests of c with a token, or
+// with the credentials of basicAuth in the user:pass form.
+func withCredentials(c *http.Client, token, basicAuth string) (*http.Client, error) {
+	t := &credentialsTransport{base: c.Transport, token: token}
+	if t.base == nil {
+		t.base = http.DefaultTransport
+	}
+	if basicAuth != "" {
+		var err error
+		if t.user, t.pass, err = parseCredentials(basicAuth); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Client{Transport: t}, nil
+}
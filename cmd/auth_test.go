@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_authenticator_wrap(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name    string
+		limit   int
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "token in query", prepare: func(r *http.Request) { r.URL.RawQuery = "token=s3cr3t" }, want: http.StatusOK},
+		{name: "wrong token in query", prepare: func(r *http.Request) { r.URL.RawQuery = "token=guess" }, want: http.StatusUnauthorized},
+		{name: "bearer token", prepare: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cr3t") }, want: http.StatusOK},
+		{name: "cookie", prepare: func(r *http.Request) { r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "s3cr3t"}) }, want: http.StatusOK},
+		{name: "basic auth", prepare: func(r *http.Request) { r.SetBasicAuth("user", "pass") }, want: http.StatusOK},
+		{name: "wrong password", prepare: func(r *http.Request) { r.SetBasicAuth("user", "guess") }, want: http.StatusUnauthorized},
+		{name: "blocked", limit: 1, prepare: func(r *http.Request) { r.SetBasicAuth("user", "pass") }, want: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAuthenticator("s3cr3t", "user:pass", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := a.wrap(ok)
+
+			// With a limit, fail once first
+			if tt.limit > 0 {
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file.txt", nil))
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+			if tt.prepare != nil {
+				tt.prepare(r)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withCredentials(t *testing.T) {
+	a, err := newAuthenticator("s3cr3t", "user:pass", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(a.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		token     string
+		basicAuth string
+		want      int
+	}{
+		{name: "none", want: http.StatusUnauthorized},
+		{name: "token", token: "s3cr3t", want: http.StatusOK},
+		{name: "basic auth", basicAuth: "user:pass", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := withCredentials(http.DefaultClient, tt.token, tt.basicAuth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := c.Get(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
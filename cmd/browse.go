@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// browseEntry describes a file or a folder of a browsed directory.
+type browseEntry struct {
+	Name     string    `json:"name"`
+	Path     string    `json:"path"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// browseHandler serves a directory tree: folders are listed in HTML, or in
+// JSON for scripts, files are downloaded one by one and any folder can be
+// downloaded as a zip archive.
+type browseHandler struct {
+	root   string
+	prefix string
+	enc    *encryptor
+	sums   *checksumCache
+
+	// download serves each file or archive download, counting it against
+	// the download limit.
+	download downloadFunc
+}
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+td { padding: 0.2em 1em 0.2em 0; }
+.size, .date { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p><a href="?zip">Download this folder as a zip archive</a></p>
+<table>
+{{- if .Parent}}
+<tr><td><a href="../">../</a></td><td></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr>
+{{- if .Dir}}
+<td><a href="{{.Path}}">{{.Name}}/</a></td><td class="size">-</td><td class="date">{{.Modified.Format "2006-01-02 15:04"}}</td><td><a href="{{.Path}}?zip">zip</a></td>
+{{- else}}
+<td><a href="{{.Path}}">{{.Name}}</a></td><td class="size">{{.Size}}</td><td class="date">{{.Modified.Format "2006-01-02 15:04"}}</td><td></td>
+{{- end}}
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// newBrowseHandler returns a handler serving root under the URL prefix, which
+// must end with a slash.
+func newBrowseHandler(root, prefix string, enc *encryptor, sums *checksumCache, download downloadFunc) (*browseHandler, error) {
+	// Resolve the root once, so that symbolic links pointing outside of it
+	// can be detected.
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return nil, err
+	}
+
+	return &browseHandler{root: resolved, prefix: prefix, enc: enc, sums: sums, download: download}, nil
+}
+
+// resolve returns the path on disk of the URL path p. It fails if p, once
+// symbolic links are followed, is not inside the root.
+func (b *browseHandler) resolve(p string) (string, error) {
+	if !strings.HasPrefix(p, b.prefix) {
+		return "", errors.New("outside of the shared folder")
+	}
+	rel := path.Clean("/" + strings.TrimPrefix(p, b.prefix))
+
+	name, err := filepath.EvalSymlinks(filepath.Join(b.root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	if !b.inside(name) {
+		return "", errors.New("outside of the shared folder")
+	}
+	return name, nil
+}
+
+// inside reports whether the resolved path name is inside the root.
+func (b *browseHandler) inside(name string) bool {
+	return name == b.root || strings.HasPrefix(name, b.root+string(filepath.Separator))
+}
+
+func (b *browseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The root is registered without its trailing slash too
+	if r.URL.Path+"/" == b.prefix {
+		http.Redirect(w, r, b.prefix, http.StatusMovedPermanently)
+		return
+	}
+
+	name, err := b.resolve(r.URL.Path)
+	if err != nil {
+		b.serveChecksum(w, r)
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !fi.IsDir() {
+		b.serveFile(w, r, name, fi)
+		return
+	}
+
+	if _, ok := r.URL.Query()["zip"]; ok {
+		b.serveZip(w, r, name)
+		return
+	}
+
+	// Relative links of the listing only work from a path ending with a slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	entries, err := b.list(name, r.URL.Path)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		http.Error(w, "cannot list folder", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			logrus.Errorf("%s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browseTemplate.Execute(w, struct {
+		Title   string
+		Parent  bool
+		Entries []browseEntry
+	}{
+		Title:   r.URL.Path,
+		Parent:  r.URL.Path != b.prefix,
+		Entries: entries,
+	}); err != nil {
+		logrus.Errorf("%s", err)
+	}
+}
+
+// list returns the content of the folder dir, whose URL path is p. Folders are
+// listed first.
+func (b *browseHandler) list(dir, p string) ([]browseEntry, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []browseEntry{}
+	for _, fi := range infos {
+		// Describe the target of symbolic links rather than the links, and
+		// hide those that cannot be followed.
+		if fi.Mode()&os.ModeSymlink != 0 {
+			resolved, err := filepath.EvalSymlinks(filepath.Join(dir, fi.Name()))
+			if err != nil || !b.inside(resolved) {
+				continue
+			}
+			target, err := os.Stat(resolved)
+			if err != nil {
+				continue
+			}
+			fi = &namedFileInfo{FileInfo: target, name: fi.Name()}
+		}
+
+		e := browseEntry{
+			Name:     fi.Name(),
+			Path:     (&url.URL{Path: p + fi.Name()}).EscapedPath(),
+			Dir:      fi.IsDir(),
+			Size:     fi.Size(),
+			Modified: fi.ModTime(),
+		}
+		if e.Dir {
+			e.Path += "/"
+			e.Size = 0
+		}
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// serveFile sends the file name as an attachment.
+func (b *browseHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, fi os.FileInfo) {
+	f, err := os.Open(name)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		http.Error(w, "cannot open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fi.Name()}))
+
+	// Encrypted files are always sent entirely
+	size := fi.Size()
+	if b.enc != nil {
+		size = -1
+	}
+	b.download(w, r, size, func() (bool, error) {
+		return serveFile(w, r, f, b.enc, b.sums)
+	})
+}
+
+// serveZip sends the folder dir as a zip archive, compressed on the fly.
+func (b *browseHandler) serveZip(w http.ResponseWriter, r *http.Request, dir string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(dir) + ".zip"}))
+	w.Header().Set("Accept-Ranges", "none")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	b.download(w, r, -1, func() (bool, error) {
+		err := serveStream(w, b.enc, b.sums, func(w io.Writer) error {
+			return writeZip(w, dir)
+		})
+		return err == nil, err
+	})
+}
+
+// serveChecksum sends the checksum of a plain file requested by adding .sha256
+// or .blake3 to its URL, or a 404 if it is not such a request.
+func (b *browseHandler) serveChecksum(w http.ResponseWriter, r *http.Request) {
+	p, header, ok := checksumOf(r.URL.Path)
+	if !ok || b.enc != nil || (header == blake3Header && !b.sums.blake3) {
+		http.NotFound(w, r)
+		return
+	}
+	name, err := b.resolve(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	b.sums.serveChecksum(w, r, name, header)
+}
+
+// namedFileInfo overrides the name of a FileInfo.
+type namedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi *namedFileInfo) Name() string {
+	return fi.name
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_browseHandler_resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	for _, d := range []string{filepath.Join(root, "sub"), filepath.Join(dir, "outside")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "sub", "file.txt"), filepath.Join(dir, "outside", "secret.txt")} {
+		if err := ioutil.WriteFile(f, []byte("plop"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBrowseHandler(root, "/root/", nil, newChecksumCache(false), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		p       string
+		want    string
+		wantErr bool
+	}{
+		{name: "root", p: "/root/", want: b.root},
+		{name: "file", p: "/root/sub/file.txt", want: filepath.Join(b.root, "sub", "file.txt")},
+		{name: "link inside", p: "/root/link/file.txt", want: filepath.Join(b.root, "sub", "file.txt")},
+		{name: "dot dot", p: "/root/../outside/secret.txt", wantErr: true},
+		{name: "link outside", p: "/root/escape/secret.txt", wantErr: true},
+		{name: "other prefix", p: "/other/sub/file.txt", wantErr: true},
+		{name: "missing", p: "/root/nope", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := b.resolve(tt.p)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_browseHandler_serveZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("plop"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	downloads := 0
+	b, err := newBrowseHandler(dir, "/root/", nil, newChecksumCache(false), func(w http.ResponseWriter, r *http.Request, size int64, send func() (bool, error)) {
+		downloads++
+		send()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// HEAD requests get the headers only, without building the archive
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/root/?zip", nil))
+	if downloads != 0 || w.Body.Len() != 0 || w.Header().Get("Content-Disposition") == "" {
+		t.Errorf("HEAD served %d downloads, %d bytes, headers %v", downloads, w.Body.Len(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/root/?zip", nil))
+	if downloads != 1 || w.Body.Len() == 0 {
+		t.Errorf("GET served %d downloads, %d bytes, want 1 and an archive", downloads, w.Body.Len())
+	}
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/blake3"
+)
+
+// Headers carrying the hex encoded checksums of the served content. They are
+// sent as trailers when the content is generated on the fly.
+const (
+	sha256Header = "X-Checksum-Sha256"
+	blake3Header = "X-Checksum-Blake3"
+)
+
+// checksumSuffixes maps the suffix of the URI serving a checksum to its
+// header.
+var checksumSuffixes = map[string]string{
+	".sha256": sha256Header,
+	".blake3": blake3Header,
+}
+
+// checksumCache computes the checksums of the shared files once, and again
+// only when they are modified.
+type checksumCache struct {
+	blake3 bool
+
+	mu      sync.Mutex
+	entries map[string]*checksumEntry
+}
+
+type checksumEntry struct {
+	etag  string
+	ready chan struct{}
+	sums  map[string]string
+	err   error
+}
+
+func newChecksumCache(blake3 bool) *checksumCache {
+	return &checksumCache{blake3: blake3, entries: map[string]*checksumEntry{}}
+}
+
+// newHashes returns the hashes to compute, by header. SHA-256 is always
+// computed, BLAKE3 only if withBlake3 is set.
+func newHashes(withBlake3 bool) map[string]hash.Hash {
+	hashes := map[string]hash.Hash{sha256Header: sha256.New()}
+	if withBlake3 {
+		hashes[blake3Header] = blake3.New()
+	}
+	return hashes
+}
+
+// get returns the checksums of the file name, by header. Concurrent calls for
+// the same file wait for a single computation.
+func (c *checksumCache) get(name string, fi os.FileInfo) (map[string]string, error) {
+	etag := fileETag(fi)
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok && e.etag == etag {
+		c.mu.Unlock()
+		<-e.ready
+		return e.sums, e.err
+	}
+	e = &checksumEntry{etag: etag, ready: make(chan struct{})}
+	c.entries[name] = e
+	c.mu.Unlock()
+
+	e.sums, e.err = c.hashFile(name)
+	close(e.ready)
+	return e.sums, e.err
+}
+
+func (c *checksumCache) hashFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hw := newHashWriter(newHashes(c.blake3))
+	if _, err := io.Copy(hw, f); err != nil {
+		return nil, err
+	}
+	return hw.sums(), nil
+}
+
+// setHeaders adds the checksums of the file name to the headers of w.
+func (c *checksumCache) setHeaders(w http.ResponseWriter, name string, fi os.FileInfo) error {
+	sums, err := c.get(name, fi)
+	if err != nil {
+		return err
+	}
+	for h, sum := range sums {
+		w.Header().Set(h, sum)
+	}
+	return nil
+}
+
+// serveChecksum writes the checksum of the file name in the format of the
+// sha256sum and b3sum tools.
+func (c *checksumCache) serveChecksum(w http.ResponseWriter, r *http.Request, name, header string) {
+	fi, err := os.Stat(name)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	sums, err := c.get(name, fi)
+	if err != nil {
+		http.Error(w, "cannot compute checksum", http.StatusInternalServerError)
+		return
+	}
+	sum, ok := sums[header]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "%s  %s\n", sum, fi.Name())
+}
+
+// declareTrailers announces the checksums that will be sent as trailers of a
+// response generated on the fly, and returns the hashWriter computing them.
+func (c *checksumCache) declareTrailers(w http.ResponseWriter) *hashWriter {
+	hw := newHashWriter(newHashes(c.blake3))
+	for header := range hw.hashes {
+		w.Header().Add("Trailer", header)
+	}
+	return hw
+}
+
+// setTrailers sends the checksums computed by hw as trailers.
+func setTrailers(w http.ResponseWriter, hw *hashWriter) {
+	for header, sum := range hw.sums() {
+		w.Header().Set(header, sum)
+	}
+}
+
+// checksumOf returns the file whose checksum is requested by p, and the header
+// of this checksum.
+func checksumOf(p string) (string, string, bool) {
+	for suffix, header := range checksumSuffixes {
+		if strings.HasSuffix(p, suffix) {
+			return strings.TrimSuffix(p, suffix), header, true
+		}
+	}
+	return "", "", false
+}
+
+// hashWriter feeds several hashes at once.
+type hashWriter struct {
+	hashes map[string]hash.Hash
+}
+
+func newHashWriter(hashes map[string]hash.Hash) *hashWriter {
+	return &hashWriter{hashes: hashes}
+}
+
+func (hw *hashWriter) Write(p []byte) (int, error) {
+	for _, h := range hw.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// sums returns the hex encoded checksums, by header.
+func (hw *hashWriter) sums() map[string]string {
+	sums := map[string]string{}
+	for header, h := range hw.hashes {
+		sums[header] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums
+}
+
+// checksumHeaders returns the checksums found in headers, or nil. Later
+// headers override earlier ones.
+func checksumHeaders(headers ...http.Header) http.Header {
+	sums := http.Header{}
+	for _, hs := range headers {
+		for _, h := range checksumSuffixes {
+			if v := hs.Get(h); v != "" {
+				sums.Set(h, v)
+			}
+		}
+	}
+	if len(sums) == 0 {
+		return nil
+	}
+	return sums
+}
+
+// checksumError is returned when downloaded content does not match its
+// checksum, or when an announced checksum is not sent.
+type checksumError struct {
+	header, got, want string
+}
+
+func (e *checksumError) Error() string {
+	if e.want == "" {
+		return fmt.Sprintf("%s announced but not sent, the download may be truncated", e.header)
+	}
+	return fmt.Sprintf("checksum mismatch: %s is %s, expected %s", e.header, e.got, e.want)
+}
+
+func isChecksumError(err error) bool {
+	_, ok := err.(*checksumError)
+	return ok
+}
+
+// verifyChecksums compares the checksums announced in headers to the ones
+// computed by hw, and returns an error on mismatch, or if a checksum is
+// declared as a trailer but missing. It reports whether a checksum was
+// verified.
+func verifyChecksums(headers http.Header, hw *hashWriter) (bool, error) {
+	verified := false
+	for header, sum := range hw.sums() {
+		want := strings.ToLower(strings.TrimSpace(headers.Get(header)))
+		if _, declared := headers[http.CanonicalHeaderKey(header)]; declared && want == "" {
+			return false, &checksumError{header: header, got: sum}
+		} else if want == "" {
+			continue
+		}
+		if want != sum {
+			return false, &checksumError{header: header, got: sum, want: want}
+		}
+		verified = true
+	}
+	return verified, nil
+}
@@ -0,0 +1,722 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with a header:
+//
+//	magic    "SHALOC"        6 bytes
+//	version  containerV2     1 byte
+//	kdf      kdfArgon2id     1 byte
+//	params                   9 bytes for argon2id, 3 bytes for scrypt
+//	salt                     16 bytes
+//	nonce    random prefix   7 bytes
+//
+// Files encrypted to X25519 recipients use kdfX25519 and have no salt. The
+// params hold the number of recipients on 1 byte, then for each one of them
+// an ephemeral public key and the random file key wrapped with the secret it
+// shares with the recipient, on 32 + 48 bytes. Files encrypted for a PAKE
+// handshake use kdfPAKE, with neither params nor salt: their random file key
+// is handed to the receiver by the handshake.
+//
+// followed by the file cut in segments of chunkSize bytes, each one sealed with
+// AES-256-GCM. The nonce of a segment is the random prefix, followed by the
+// segment index on 4 bytes and by a byte set to 1 for the last segment only, so
+// segments cannot be reordered, dropped or truncated without being noticed. The
+// header is used as additional data of every segment.
+//
+// Version 1 sealed the whole file at once with a 12 bytes nonce instead of the
+// prefix. It can still be read, as well as the legacy AES-256-CBC format of
+// files without the magic.
+const (
+	containerMagic = "SHALOC"
+	containerV1    = 1
+	containerV2    = 2
+
+	kdfScrypt   = 1
+	kdfArgon2id = 2
+	kdfX25519   = 3
+	kdfPAKE     = 4
+
+	saltSize        = 16
+	keySize         = 32
+	wrappedKeySize  = keySize + 16
+	noncePrefixSize = 7
+
+	chunkSize       = 64 * 1024
+	sealedChunkSize = chunkSize + 16
+)
+
+// Default key derivation cost, following the recommendations of RFC 9106 for
+// memory constrained environments.
+const (
+	defaultKDF        = "argon2id"
+	defaultKDFTime    = 3
+	defaultKDFMemory  = 64
+	defaultKDFThreads = 4
+
+	// maxKDFMemory bounds the memory, in MiB, a header can ask for, so that a
+	// crafted file cannot exhaust the memory of the receiver. maxKDFTime and
+	// maxKDFThreads bound the passes of argon2id and the parallelism, which
+	// multiplies the work of scrypt, so that it cannot keep it busy for hours.
+	maxKDFMemory  = 4096
+	maxKDFTime    = 64
+	maxKDFThreads = 32
+)
+
+// x25519Info is the HKDF info used to derive wrapping keys from X25519 shared
+// secrets.
+const x25519Info = "shaloc-x25519"
+
+// errDecrypt is returned when a file cannot be authenticated, which means either
+// the key is wrong or the file has been modified.
+var errDecrypt = errors.New("decryption failed: wrong key or corrupted file")
+
+// kdfParams holds what is needed to derive a key from a passphrase.
+type kdfParams struct {
+	id   byte
+	salt []byte
+
+	// scrypt parameters
+	logN uint8
+	r    uint8
+	p    uint8
+
+	// argon2id parameters, memory is in KiB
+	time    uint32
+	memory  uint32
+	threads uint8
+
+	// X25519 recipients
+	stanzas []x25519Stanza
+}
+
+// x25519Stanza holds the file key wrapped for one recipient.
+type x25519Stanza struct {
+	ephemeral [32]byte
+	wrapped   []byte
+}
+
+// secrets holds what the receiver can use to decrypt a file.
+type secrets struct {
+	passphrase []byte
+	// limit is the highest cost of key derivation accepted from a header,
+	// if set.
+	limit      *kdfLimit
+	identities [][32]byte
+	// fileKey is the key of files encrypted for a PAKE handshake.
+	fileKey []byte
+}
+
+// kdfLimit bounds the cost of the key derivations run for a header. memory is
+// in MiB.
+type kdfLimit struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// newKDFParams returns the parameters of the key derivation function named
+// kdf, with a fresh random salt. memory is in MiB, time is only used by
+// argon2id and threads is the parallelism of both functions.
+func newKDFParams(kdf string, time, memory uint32, threads uint8) (kdfParams, error) {
+	if memory == 0 || memory > maxKDFMemory {
+		return kdfParams{}, fmt.Errorf("KDF memory must be between 1 and %d MiB", maxKDFMemory)
+	}
+	if threads == 0 || threads > maxKDFThreads {
+		return kdfParams{}, fmt.Errorf("KDF threads must be between 1 and %d", maxKDFThreads)
+	}
+
+	var k kdfParams
+	switch kdf {
+	case "argon2id":
+		if time == 0 || time > maxKDFTime {
+			return kdfParams{}, fmt.Errorf("KDF time must be between 1 and %d", maxKDFTime)
+		}
+		k = kdfParams{id: kdfArgon2id, time: time, memory: memory * 1024, threads: threads}
+	case "scrypt":
+		// scrypt uses 128 * N * r bytes of memory, so pick the largest N that
+		// fits in the requested amount.
+		k = kdfParams{id: kdfScrypt, r: 8, p: threads}
+		for k.logN = 1; 128<<(k.logN+1)*uint64(k.r) <= uint64(memory)<<20; k.logN++ {
+		}
+	default:
+		return kdfParams{}, fmt.Errorf("unknown key derivation function %q, use argon2id or scrypt", kdf)
+	}
+
+	k.salt = make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, k.salt); err != nil {
+		return kdfParams{}, err
+	}
+	return k, nil
+}
+
+// deriveKey derives a 32 bytes key from the passphrase p.
+func (k kdfParams) deriveKey(p []byte) ([]byte, error) {
+	switch k.id {
+	case kdfScrypt:
+		if k.logN == 0 || k.logN > 30 || 128<<k.logN*uint64(k.r) > maxKDFMemory<<20 || k.p == 0 || k.p > maxKDFThreads {
+			return nil, fmt.Errorf("invalid scrypt cost 2^%d r=%d p=%d", k.logN, k.r, k.p)
+		}
+		return scrypt.Key(p, k.salt, 1<<k.logN, int(k.r), int(k.p), keySize)
+	case kdfArgon2id:
+		if k.time == 0 || k.time > maxKDFTime || k.threads == 0 || k.threads > maxKDFThreads || k.memory == 0 || k.memory > maxKDFMemory*1024 {
+			return nil, fmt.Errorf("invalid argon2id cost t=%d m=%d p=%d", k.time, k.memory, k.threads)
+		}
+		return argon2.IDKey(p, k.salt, k.time, k.memory, k.threads, keySize), nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation function %d", k.id)
+	}
+}
+
+// within returns an error if deriving a key with k costs more than l allows.
+func (k kdfParams) within(l kdfLimit) error {
+	var ok bool
+	switch k.id {
+	case kdfScrypt:
+		ok = k.logN <= 30 && 128<<k.logN*uint64(k.r) <= uint64(l.memory)<<20 && k.p <= l.threads
+	case kdfArgon2id:
+		ok = k.time <= l.time && k.memory <= l.memory*1024 && k.threads <= l.threads
+	default:
+		ok = true
+	}
+	if !ok {
+		return errors.New("the key derivation of this file costs more than accepted")
+	}
+	return nil
+}
+
+// marshal returns the binary representation of k, as stored in the header.
+func (k kdfParams) marshal() []byte {
+	b := []byte{k.id}
+	switch k.id {
+	case kdfScrypt:
+		b = append(b, k.logN, k.r, k.p)
+	case kdfArgon2id:
+		var params [9]byte
+		binary.BigEndian.PutUint32(params[0:], k.time)
+		binary.BigEndian.PutUint32(params[4:], k.memory)
+		params[8] = k.threads
+		b = append(b, params[:]...)
+	case kdfX25519:
+		b = append(b, byte(len(k.stanzas)))
+		for _, st := range k.stanzas {
+			b = append(b, st.ephemeral[:]...)
+			b = append(b, st.wrapped...)
+		}
+	}
+	return append(b, k.salt...)
+}
+
+// readKDFParams reads KDF parameters from r.
+func readKDFParams(r io.Reader) (kdfParams, error) {
+	var id [1]byte
+	if _, err := io.ReadFull(r, id[:]); err != nil {
+		return kdfParams{}, errDecrypt
+	}
+
+	k := kdfParams{id: id[0], salt: make([]byte, saltSize)}
+	switch k.id {
+	case kdfScrypt:
+		var params [3]byte
+		if _, err := io.ReadFull(r, params[:]); err != nil {
+			return kdfParams{}, errDecrypt
+		}
+		k.logN, k.r, k.p = params[0], params[1], params[2]
+	case kdfArgon2id:
+		var params [9]byte
+		if _, err := io.ReadFull(r, params[:]); err != nil {
+			return kdfParams{}, errDecrypt
+		}
+		k.time = binary.BigEndian.Uint32(params[0:])
+		k.memory = binary.BigEndian.Uint32(params[4:])
+		k.threads = params[8]
+	case kdfX25519:
+		var count [1]byte
+		if _, err := io.ReadFull(r, count[:]); err != nil || count[0] == 0 {
+			return kdfParams{}, errDecrypt
+		}
+		for i := 0; i < int(count[0]); i++ {
+			st := x25519Stanza{wrapped: make([]byte, wrappedKeySize)}
+			if _, err := io.ReadFull(r, st.ephemeral[:]); err != nil {
+				return kdfParams{}, errDecrypt
+			}
+			if _, err := io.ReadFull(r, st.wrapped); err != nil {
+				return kdfParams{}, errDecrypt
+			}
+			k.stanzas = append(k.stanzas, st)
+		}
+		k.salt = nil
+		return k, nil
+	case kdfPAKE:
+		k.salt = nil
+		return k, nil
+	default:
+		return kdfParams{}, fmt.Errorf("unknown key derivation function %d", k.id)
+	}
+
+	if _, err := io.ReadFull(r, k.salt); err != nil {
+		return kdfParams{}, errDecrypt
+	}
+	return k, nil
+}
+
+// fileKey returns the key of a file encrypted with the parameters k, using the
+// passphrase or the identities in s.
+func (k kdfParams) fileKey(s *secrets) ([]byte, error) {
+	switch k.id {
+	case kdfX25519:
+	case kdfPAKE:
+		if s.fileKey == nil {
+			return nil, errors.New("this file is encrypted for a handshake with a code, use get --pake")
+		}
+		return s.fileKey, nil
+	default:
+		if s.passphrase == nil {
+			return nil, errors.New("this file is encrypted with a passphrase, use --aes")
+		}
+		if s.limit != nil {
+			if err := k.within(*s.limit); err != nil {
+				return nil, err
+			}
+		}
+		return k.deriveKey(s.passphrase)
+	}
+
+	if len(s.identities) == 0 {
+		return nil, errors.New("this file is encrypted to public keys, use --identity")
+	}
+	for _, id := range s.identities {
+		for _, st := range k.stanzas {
+			if key, err := unwrapFileKey(id, st); err == nil {
+				return key, nil
+			}
+		}
+	}
+	return nil, errors.New("none of the identities can decrypt this file")
+}
+
+// wrappingKey derives the key used to wrap a file key from the secret shared
+// between an ephemeral key and a recipient.
+func wrappingKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(x25519Info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// wrapFileKey wraps fileKey for the X25519 public key recipient.
+func wrapFileKey(fileKey []byte, recipient [32]byte) (x25519Stanza, error) {
+	var st x25519Stanza
+
+	var ephemeral, shared [32]byte
+	if _, err := io.ReadFull(rand.Reader, ephemeral[:]); err != nil {
+		return st, err
+	}
+	curve25519.ScalarBaseMult(&st.ephemeral, &ephemeral)
+	curve25519.ScalarMult(&shared, &ephemeral, &recipient)
+	if shared == [32]byte{} {
+		return st, errors.New("invalid recipient public key")
+	}
+
+	key, err := wrappingKey(shared[:], st.ephemeral[:], recipient[:])
+	if err != nil {
+		return st, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return st, err
+	}
+
+	// Each wrapping key is used only once, so the nonce can be constant.
+	st.wrapped = aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)
+	return st, nil
+}
+
+// unwrapFileKey returns the file key wrapped in st if it was wrapped for the
+// public key of identity.
+func unwrapFileKey(identity [32]byte, st x25519Stanza) ([]byte, error) {
+	var recipient, shared [32]byte
+	curve25519.ScalarBaseMult(&recipient, &identity)
+	curve25519.ScalarMult(&shared, &identity, &st.ephemeral)
+	if shared == [32]byte{} {
+		return nil, errDecrypt
+	}
+
+	key, err := wrappingKey(shared[:], st.ephemeral[:], recipient[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, make([]byte, aead.NonceSize()), st.wrapped, nil)
+}
+
+// newGCM returns an AES-256-GCM AEAD using key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptor holds a file key, so that it can be used to encrypt several
+// streams without paying for the key derivation each time.
+type encryptor struct {
+	kdf  kdfParams
+	aead cipher.AEAD
+}
+
+// newEncryptor derives a key from the passphrase p with the parameters k.
+func newEncryptor(p []byte, k kdfParams) (*encryptor, error) {
+	key, err := k.deriveKey(p)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{kdf: k, aead: aead}, nil
+}
+
+// newRecipientsEncryptor generates a random file key that can be unwrapped by
+// any of the X25519 recipients.
+func newRecipientsEncryptor(recipients [][32]byte) (*encryptor, error) {
+	if len(recipients) == 0 || len(recipients) > 255 {
+		return nil, errors.New("between 1 and 255 recipients are needed")
+	}
+
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	k := kdfParams{id: kdfX25519}
+	for _, r := range recipients {
+		st, err := wrapFileKey(key, r)
+		if err != nil {
+			return nil, err
+		}
+		k.stanzas = append(k.stanzas, st)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptor{kdf: k, aead: aead}, nil
+}
+
+// newPAKEEncryptor generates a random file key, to be handed to the receivers
+// by PAKE handshakes. It returns the key along with the encryptor.
+func newPAKEEncryptor() (*encryptor, []byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &encryptor{kdf: kdfParams{id: kdfPAKE}, aead: aead}, key, nil
+}
+
+// headerSize returns the size of the header written by e.
+func (e *encryptor) headerSize() int64 {
+	return int64(len(containerMagic) + 1 + len(e.kdf.marshal()) + noncePrefixSize)
+}
+
+// encryptedSize returns the size of an encrypted stream whose plaintext is n
+// bytes long, header included.
+func (e *encryptor) encryptedSize(n int64) int64 {
+	chunks := (n + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return e.headerSize() + n + chunks*int64(e.aead.Overhead())
+}
+
+// newWriter returns a WriteCloser that encrypts everything written to it into
+// w. Close must be called to seal the last segment.
+func (e *encryptor) newWriter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(containerMagic), containerV2)
+	header = append(header, e.kdf.marshal()...)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		w:      w,
+		aead:   e.aead,
+		header: header,
+		nonce:  newStreamNonce(prefix),
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+// newStreamNonce returns a segment nonce starting with prefix.
+func newStreamNonce(prefix []byte) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	return nonce
+}
+
+// setStreamNonce updates the counter and the last segment flag of nonce.
+func setStreamNonce(nonce []byte, counter uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+	buf     []byte
+	out     []byte
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once we know it is not the last one.
+		if len(ew.buf) == chunkSize {
+			if err := ew.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(ew.buf[len(ew.buf):chunkSize], p)
+		ew.buf = ew.buf[:len(ew.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close seals the last segment. It does not close the underlying writer.
+func (ew *encryptWriter) Close() error {
+	return ew.seal(true)
+}
+
+func (ew *encryptWriter) seal(last bool) error {
+	if ew.counter == ^uint32(0) {
+		return errors.New("file too large to be encrypted")
+	}
+	setStreamNonce(ew.nonce, ew.counter, last)
+	ew.out = ew.aead.Seal(ew.out[:0], ew.nonce, ew.buf, ew.header)
+	ew.counter++
+	ew.buf = ew.buf[:0]
+
+	_, err := ew.w.Write(ew.out)
+	return err
+}
+
+// newDecryptReader returns a Reader that decrypts what is read from r with the
+// passphrase or the identities in s. Streams are decrypted a segment at a time,
+// the older formats are loaded in memory.
+func newDecryptReader(r io.Reader, s *secrets) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, sealedChunkSize+1)
+
+	magic, err := br.Peek(len(containerMagic))
+	if err != nil || string(magic) != containerMagic {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		if s.passphrase == nil {
+			return nil, errors.New("this file is encrypted with a passphrase, use --aes")
+		}
+		plaintext, err := openLegacy(s.passphrase, data)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(plaintext), nil
+	}
+
+	if _, err := br.Discard(len(containerMagic)); err != nil {
+		return nil, err
+	}
+	version, err := br.ReadByte()
+	if err != nil {
+		return nil, errDecrypt
+	}
+	if version != containerV1 && version != containerV2 {
+		return nil, fmt.Errorf("unsupported format version %d, try updating shaloc", version)
+	}
+
+	k, err := readKDFParams(br)
+	if err != nil {
+		return nil, err
+	}
+	key, err := k.fileKey(s)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(containerMagic), version)
+	header = append(header, k.marshal()...)
+
+	if version == containerV1 {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(br, nonce); err != nil {
+			return nil, errDecrypt
+		}
+		header = append(header, nonce...)
+
+		ciphertext, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+		if err != nil {
+			return nil, errDecrypt
+		}
+		return bytes.NewReader(plaintext), nil
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, errDecrypt
+	}
+	header = append(header, prefix...)
+
+	return &decryptReader{
+		r:      br,
+		aead:   aead,
+		header: header,
+		nonce:  newStreamNonce(prefix),
+		in:     make([]byte, sealedChunkSize),
+	}, nil
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	nonce   []byte
+	counter uint32
+	in      []byte
+	out     []byte
+	done    bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.out) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, dr.out)
+	dr.out = dr.out[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment.
+func (dr *decryptReader) open() error {
+	n, err := io.ReadFull(dr.r, dr.in)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The last segment is either shorter than the others, or followed by
+	// nothing at all.
+	last := n < len(dr.in)
+	if !last {
+		if _, err := dr.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	setStreamNonce(dr.nonce, dr.counter, last)
+	out, err := dr.aead.Open(dr.in[:0], dr.nonce, dr.in[:n], dr.header)
+	if err != nil {
+		return errDecrypt
+	}
+	dr.counter++
+	dr.out = out
+	dr.done = last
+	return nil
+}
+
+// openLegacy decrypts files produced by shaloc versions that used AES-256-CBC
+// with the SHA-256 of the passphrase as key. This format is not authenticated,
+// so a wrong key cannot always be detected.
+func openLegacy(p, ciphertext []byte) ([]byte, error) {
+	key := sha256.Sum256(p)
+
+	// cipertext has the original plaintext size in the first 8 bytes, then IV
+	// in the next 16 bytes, then the actual ciphertext in the rest of the buffer.
+	// Read the original plaintext size, and the IV.
+	var origSize uint64
+	buf := bytes.NewReader(ciphertext)
+	if err := binary.Read(buf, binary.LittleEndian, &origSize); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(buf, iv); err != nil {
+		return nil, err
+	}
+
+	// The remaining ciphertext has size=paddedSize.
+	paddedSize := len(ciphertext) - 8 - aes.BlockSize
+	if paddedSize%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("want padded plaintext size to be aligned to block size")
+	}
+	if origSize > uint64(paddedSize) {
+		return nil, errDecrypt
+	}
+	plaintext := make([]byte, paddedSize)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	mode := cipher.NewCBCDecrypter(block, iv)
+	mode.CryptBlocks(plaintext, ciphertext[8+aes.BlockSize:])
+
+	return plaintext[:origSize], nil
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func Test_decryptReader(t *testing.T) {
+	k, err := newKDFParams("argon2id", 1, 8, 1)
+	if err != nil {
+		t.Fatalf("newKDFParams() error = %v", err)
+	}
+	enc, err := newEncryptor([]byte("passphrase"), k)
+	if err != nil {
+		t.Fatalf("newEncryptor() error = %v", err)
+	}
+
+	seal := func(plaintext []byte) []byte {
+		var buf bytes.Buffer
+		ew, err := enc.newWriter(&buf)
+		if err != nil {
+			t.Fatalf("newWriter() error = %v", err)
+		}
+		if _, err := ew.Write(plaintext); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := ew.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if got, want := enc.encryptedSize(int64(len(plaintext))), int64(buf.Len()); got != want {
+			t.Fatalf("encryptedSize() = %d, want %d", got, want)
+		}
+		return buf.Bytes()
+	}
+
+	large := bytes.Repeat([]byte("shaloc"), chunkSize)
+	sealed := seal(large)
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		p       string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{name: "empty", p: "passphrase", data: seal(nil), want: []byte{}},
+		{name: "one byte", p: "passphrase", data: seal([]byte("a")), want: []byte("a")},
+		{name: "one chunk", p: "passphrase", data: seal(large[:chunkSize]), want: large[:chunkSize]},
+		{name: "one chunk and a byte", p: "passphrase", data: seal(large[:chunkSize+1]), want: large[:chunkSize+1]},
+		{name: "several chunks", p: "passphrase", data: sealed, want: large},
+		{name: "wrong key", p: "passphrose", data: sealed, wantErr: true},
+		{name: "tampered", p: "passphrase", data: tampered, wantErr: true},
+		{name: "truncated", p: "passphrase", data: sealed[:enc.headerSize()+2*sealedChunkSize], wantErr: true},
+		{name: "header only", p: "passphrase", data: sealed[:enc.headerSize()], wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := func() ([]byte, error) {
+				r, err := newDecryptReader(bytes.NewReader(tt.data), &secrets{passphrase: []byte(tt.p)})
+				if err != nil {
+					return nil, err
+				}
+				return ioutil.ReadAll(r)
+			}()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDecryptReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !bytes.Equal(got, tt.want) {
+				t.Errorf("newDecryptReader() = %d bytes, want %d bytes", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func Test_readKDFParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		kdf     string
+		time    uint32
+		memory  uint32
+		threads uint8
+		wantErr bool
+	}{
+		{name: "argon2id", kdf: "argon2id", time: 1, memory: 8, threads: 2},
+		{name: "scrypt", kdf: "scrypt", memory: 16, threads: 1},
+		{name: "unknown", kdf: "md5", memory: 8, threads: 1, wantErr: true},
+		{name: "no memory", kdf: "argon2id", time: 1, threads: 1, wantErr: true},
+		{name: "too much memory", kdf: "scrypt", memory: maxKDFMemory + 1, threads: 1, wantErr: true},
+		{name: "too many passes", kdf: "argon2id", time: maxKDFTime + 1, memory: 8, threads: 1, wantErr: true},
+		{name: "too many threads", kdf: "scrypt", memory: 16, threads: maxKDFThreads + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := newKDFParams(tt.kdf, tt.time, tt.memory, tt.threads)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newKDFParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got, err := readKDFParams(bytes.NewReader(k.marshal()))
+			if err != nil {
+				t.Fatalf("readKDFParams() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, k) {
+				t.Errorf("readKDFParams() = %+v, want %+v", got, k)
+			}
+
+			want, _ := k.deriveKey([]byte("passphrase"))
+			if key, err := got.deriveKey([]byte("passphrase")); err != nil || !bytes.Equal(key, want) {
+				t.Errorf("deriveKey() = %x, %v, want %x", key, err, want)
+			}
+		})
+	}
+}
+
+func Test_kdfParams_cost(t *testing.T) {
+	limit := kdfLimit{time: defaultKDFTime, memory: defaultKDFMemory, threads: defaultKDFThreads}
+	tests := []struct {
+		name       string
+		k          kdfParams
+		wantDerive bool
+		wantWithin bool
+	}{
+		{name: "default", k: kdfParams{id: kdfArgon2id, time: defaultKDFTime, memory: 8 * 1024, threads: 1}, wantDerive: true, wantWithin: true},
+		{name: "endless argon2id", k: kdfParams{id: kdfArgon2id, time: 1<<32 - 1, memory: 8 * 1024, threads: 1}},
+		{name: "slower than the receiver", k: kdfParams{id: kdfArgon2id, time: defaultKDFTime + 1, memory: 8 * 1024, threads: 1}, wantDerive: true},
+		{name: "more memory than the receiver", k: kdfParams{id: kdfArgon2id, time: 1, memory: (defaultKDFMemory + 1) * 1024, threads: 1}, wantDerive: true},
+		{name: "endless scrypt", k: kdfParams{id: kdfScrypt, logN: 4, r: 8, p: 255}},
+		{name: "scrypt", k: kdfParams{id: kdfScrypt, logN: 4, r: 8, p: 1}, wantDerive: true, wantWithin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.k.salt = make([]byte, saltSize)
+			if (tt.k.within(limit) == nil) != tt.wantWithin {
+				t.Errorf("within() = %v, want %v", tt.k.within(limit), tt.wantWithin)
+			}
+
+			// Only derive the keys that are cheap enough to be tested
+			if !tt.wantDerive {
+				if _, err := tt.k.deriveKey([]byte("passphrase")); err == nil {
+					t.Error("deriveKey() accepted the cost")
+				}
+			}
+
+			_, err := tt.k.fileKey(&secrets{passphrase: []byte("passphrase"), limit: &limit})
+			if (err == nil) != tt.wantWithin {
+				t.Errorf("fileKey() error = %v, want within %v", err, tt.wantWithin)
+			}
+		})
+	}
+}
+
+func Test_newRecipientsEncryptor(t *testing.T) {
+	newIdentity := func() (id, public [32]byte) {
+		if _, err := rand.Read(id[:]); err != nil {
+			t.Fatalf("rand.Read() error = %v", err)
+		}
+		curve25519.ScalarBaseMult(&public, &id)
+		return id, public
+	}
+	alice, alicePub := newIdentity()
+	bob, bobPub := newIdentity()
+	eve, _ := newIdentity()
+
+	enc, err := newRecipientsEncryptor([][32]byte{alicePub, bobPub})
+	if err != nil {
+		t.Fatalf("newRecipientsEncryptor() error = %v", err)
+	}
+	var buf bytes.Buffer
+	ew, err := enc.newWriter(&buf)
+	if err != nil {
+		t.Fatalf("newWriter() error = %v", err)
+	}
+	plaintext := []byte("SHAre files LOCally !")
+	ew.Write(plaintext)
+	ew.Close()
+
+	tests := []struct {
+		name    string
+		sec     *secrets
+		wantErr bool
+	}{
+		{name: "first recipient", sec: &secrets{identities: [][32]byte{alice}}},
+		{name: "second recipient", sec: &secrets{identities: [][32]byte{eve, bob}}},
+		{name: "not a recipient", sec: &secrets{identities: [][32]byte{eve}}, wantErr: true},
+		{name: "passphrase", sec: &secrets{passphrase: []byte("passphrase")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newDecryptReader(bytes.NewReader(buf.Bytes()), tt.sec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDecryptReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("newDecryptReader() = %q, %v, want %q", got, err, plaintext)
+			}
+		})
+	}
+}
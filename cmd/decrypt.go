@@ -20,27 +20,51 @@ var decryptCmd = &cobra.Command{
 
 This will decrypt toto.txt:
   shaloc decrypt toto.txt
-	
+
+This will decrypt toto.txt with a key created by 'shaloc keygen':
+  shaloc decrypt toto.txt --identity key.txt
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Print("Type decryption key:\n")
-		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
-		if err != nil {
-			logrus.Fatalf("%s", err)
+		identityFiles, _ := cmd.Flags().GetStringArray("identity")
+
+		sec := &secrets{}
+		for _, f := range identityFiles {
+			ids, err := readIdentities(f)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			sec.identities = append(sec.identities, ids...)
+		}
+
+		// Without identities, the file must have been encrypted with a passphrase
+		if len(sec.identities) == 0 {
+			fmt.Print("Type decryption key:\n")
+			bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			sec.passphrase = bytePassword
 		}
 
 		// Init and start the spinner
 		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
 		s.Start()
-		decryptFile(string(bytePassword), args[0])
-		os.Remove(args[0])
+		out, err := decryptFile(sec, args[0])
 		s.Stop()
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		if err := os.Remove(args[0]); err != nil {
+			logrus.Errorf("%s", err)
+		}
 
-		fmt.Printf("Decrypted %s in %s.dec\n", args[0], args[0])
+		fmt.Printf("Decrypted %s in %s\n", args[0], out)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(decryptCmd)
+	decryptCmd.Flags().StringArray("identity", nil, "Identity file created by 'shaloc keygen' to decrypt the file with. Can be repeated.")
 }
@@ -0,0 +1,408 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// mdnsService is the DNS-SD service type advertised by share.
+	mdnsService = "_shaloc._tcp"
+	mdnsDomain  = "local."
+
+	// discoverTimeout is how long the network is browsed by default.
+	discoverTimeout = 3 * time.Second
+
+	// codeSaltSize is the size of the salts of the code verifiers, and
+	// codeVerifierSize the size of the verifiers: enough to tell most other
+	// shares apart, too few bits to narrow the codes down much.
+	codeSaltSize     = 16
+	codeVerifierSize = 2
+	// codeVerifierMemory is the memory, in KiB, used to compute a verifier.
+	codeVerifierMemory = 16 * 1024
+)
+
+// discoverCmd represents the discover command
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "List the shares advertised on the local network",
+	Long: `discover lists the files and folders shared with 'shaloc share --advertise' on
+the local network, using mDNS. For example:
+
+This will list the shares found within 3 seconds:
+  shaloc discover
+
+Any of them can then be downloaded by name, without knowing its URL:
+  shaloc get notes.txt
+`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		shares, err := discover(timeout)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		if len(shares) == 0 {
+			fmt.Println("No share found on the local network.")
+			return
+		}
+		printShares(os.Stdout, shares)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+	discoverCmd.Flags().Duration("timeout", discoverTimeout, "How long to wait for the shares to answer.")
+}
+
+// discoveredShare is a shared item advertised on the local network.
+type discoveredShare struct {
+	// name is the name of the downloaded file, or archive for folders.
+	name string
+	url  string
+	// size is the size of shared files, or -1 for folders.
+	size int64
+	// enc is how the content is encrypted: none, aes, x25519 or pake.
+	enc string
+	// auth is set when the share requires a token or credentials.
+	auth bool
+	// code is set when the share is at a word code, which is not announced:
+	// url is the root of the server then, verifier tells whether a code is
+	// the one of the share with salt, and browse is set if the item is a
+	// browsable folder.
+	code     bool
+	salt     []byte
+	verifier []byte
+	browse   bool
+}
+
+// encryptionName returns how enc encrypts the content, as advertised.
+func encryptionName(enc *encryptor) string {
+	switch {
+	case enc == nil:
+		return "none"
+	case enc.kdf.id == kdfX25519:
+		return "x25519"
+	case enc.kdf.id == kdfPAKE:
+		return "pake"
+	default:
+		return "aes"
+	}
+}
+
+// advertise announces the items of s on the local network with mDNS, for a
+// server listening on port. secure is set when it serves HTTPS, and auth when
+// it requires authentication. It returns a function withdrawing the
+// announces.
+func (s *session) advertise(port int, secure, auth bool) (func(), error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "shaloc"
+	}
+
+	var servers []*zeroconf.Server
+	stop := func() {
+		for _, srv := range servers {
+			srv.Shutdown()
+		}
+	}
+	for _, it := range s.items {
+		// Word codes are secrets, they are not revealed in the announces
+		instance := it.uri
+		if it.code {
+			instance = filepath.Base(it.path)
+		}
+		txt, err := s.txtRecords(it, secure, auth)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		srv, err := zeroconf.Register(instance+" on "+host, mdnsService, mdnsDomain, port, txt, nil)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return stop, nil
+}
+
+// txtRecords describes it in the TXT records of its announce. Items at a word
+// code are announced with a verifier of the code, under a random salt.
+func (s *session) txtRecords(it *shareItem, secure, auth bool) ([]string, error) {
+	name := filepath.Base(it.path)
+	p := it.urlPath(s.browse)
+	switch {
+	case it.folder && s.browse:
+		name += ".zip"
+		p += "?zip"
+	case it.folder:
+		name += archiveExtensions[s.format]
+	}
+
+	txt := []string{
+		"name=" + name,
+		"enc=" + encryptionName(s.enc),
+	}
+	switch {
+	case it.code:
+		salt := make([]byte, codeSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		txt = append(txt, "code=1", "salt="+hex.EncodeToString(salt), "verifier="+hex.EncodeToString(codeVerifier(it.uri, salt)))
+		if it.folder && s.browse {
+			txt = append(txt, "browse=1")
+		}
+	default:
+		txt = append(txt, "path="+p)
+	}
+	if !it.folder {
+		txt = append(txt, "size="+strconv.FormatInt(it.size, 10))
+	}
+	if secure {
+		txt = append(txt, "tls=1")
+	}
+	if auth {
+		txt = append(txt, "auth=1")
+	}
+	return txt, nil
+}
+
+// codeVerifier returns the verifier of code announced with salt, which tells
+// the clients knowing the code which servers to ask for it, so that it is not
+// sent to the others. It is short and slow to compute, so that it does not
+// help guessing the code.
+func codeVerifier(code string, salt []byte) []byte {
+	return argon2.IDKey([]byte(code), salt, 1, codeVerifierMemory, 1, 32)[:codeVerifierSize]
+}
+
+// matchesCode reports whether sh announces the verifier of code.
+func (sh discoveredShare) matchesCode(code string) bool {
+	return sh.code && len(sh.verifier) == codeVerifierSize && subtle.ConstantTimeCompare(codeVerifier(code, sh.salt), sh.verifier) == 1
+}
+
+// parseShare returns the share announced by the TXT records txt, for a server
+// at addr.
+func parseShare(addr string, txt []string) (discoveredShare, bool) {
+	fields := map[string]string{}
+	for _, t := range txt {
+		if k, v, ok := strings.Cut(t, "="); ok {
+			fields[k] = v
+		}
+	}
+	code := fields["code"] == "1"
+	var salt, verifier []byte
+	if code {
+		fields["path"] = "/"
+		var err1, err2 error
+		salt, err1 = hex.DecodeString(fields["salt"])
+		verifier, err2 = hex.DecodeString(fields["verifier"])
+		if err1 != nil || err2 != nil || len(salt) == 0 || len(verifier) != codeVerifierSize {
+			return discoveredShare{}, false
+		}
+	}
+	if fields["name"] == "" || !strings.HasPrefix(fields["path"], "/") {
+		return discoveredShare{}, false
+	}
+
+	scheme := "http"
+	if fields["tls"] == "1" {
+		scheme = "https"
+	}
+	sh := discoveredShare{
+		name:     filepath.Base(fields["name"]),
+		url:      scheme + "://" + addr + fields["path"],
+		size:     -1,
+		enc:      fields["enc"],
+		auth:     fields["auth"] == "1",
+		code:     code,
+		salt:     salt,
+		verifier: verifier,
+		browse:   fields["browse"] == "1",
+	}
+	if n, err := strconv.ParseInt(fields["size"], 10, 64); err == nil {
+		sh.size = n
+	}
+	return sh, true
+}
+
+// discover browses the local network for shares during timeout.
+func discover(timeout time.Duration) ([]discoveredShare, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Browse(ctx, mdnsService, mdnsDomain, entries); err != nil {
+		return nil, err
+	}
+
+	var shares []discoveredShare
+	seen := map[string]bool{}
+	for e := range entries {
+		var ip net.IP
+		switch {
+		case len(e.AddrIPv4) > 0:
+			ip = e.AddrIPv4[0]
+		case len(e.AddrIPv6) > 0:
+			ip = e.AddrIPv6[0]
+		default:
+			continue
+		}
+
+		// The items at a word code all have the root of their server as URL
+		sh, ok := parseShare(net.JoinHostPort(ip.String(), strconv.Itoa(e.Port)), e.Text)
+		key := sh.url + " " + hex.EncodeToString(sh.salt)
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		shares = append(shares, sh)
+	}
+
+	sort.Slice(shares, func(i, j int) bool {
+		return shares[i].name < shares[j].name
+	})
+	return shares, nil
+}
+
+// printShares lists shares in a numbered table.
+func printShares(w io.Writer, shares []discoveredShare) {
+	for i, sh := range shares {
+		size := "folder"
+		if sh.size >= 0 {
+			size = formatBytes(sh.size)
+		}
+		var flags []string
+		if sh.enc != "" && sh.enc != "none" {
+			flags = append(flags, "encrypted ("+sh.enc+")")
+		}
+		if sh.auth {
+			flags = append(flags, "authentication required")
+		}
+		if sh.code {
+			flags = append(flags, "code required")
+		}
+		fmt.Fprintf(w, "%2d) %s  %s  %s  %s\n", i+1, sh.name, size, sh.url, strings.Join(flags, ", "))
+	}
+}
+
+// pickShare returns the share called name, or asks which one to pick if name
+// is empty.
+func pickShare(shares []discoveredShare, name string, in io.Reader, out io.Writer) (discoveredShare, error) {
+	if len(shares) == 0 {
+		return discoveredShare{}, fmt.Errorf("no share found on the local network")
+	}
+
+	// Shares at a word code can only be found with it
+	var reachable []discoveredShare
+	for _, sh := range shares {
+		if !sh.code {
+			reachable = append(reachable, sh)
+		}
+	}
+	shares = reachable
+	if len(shares) == 0 {
+		return discoveredShare{}, fmt.Errorf("no share found on the local network")
+	}
+
+	if name != "" {
+		var matches []discoveredShare
+		for _, sh := range shares {
+			if sh.name == name {
+				matches = append(matches, sh)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return discoveredShare{}, fmt.Errorf("no share called %s found on the local network", name)
+		case 1:
+			return matches[0], nil
+		}
+		// Several hosts share the same name, let the user choose
+		shares = matches
+	}
+
+	printShares(out, shares)
+	fmt.Fprintf(out, "Share to download [1-%d]: ", len(shares))
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return discoveredShare{}, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || n < 1 || n > len(shares) {
+		return discoveredShare{}, fmt.Errorf("invalid choice %s", strings.TrimSpace(line))
+	}
+	return shares[n-1], nil
+}
+
+// findCode returns the share at the word code among shares. Since the codes
+// are not announced, the servers announcing a verifier of this one are asked
+// whether they serve it. A verifier announced twice was copied from another
+// share, so the code is then sent to none of them.
+func findCode(shares []discoveredShare, code string) (discoveredShare, error) {
+	var matching []discoveredShare
+	salts := map[string]bool{}
+	for _, sh := range shares {
+		if !sh.matchesCode(code) {
+			continue
+		}
+		if salts[string(sh.salt)] {
+			return discoveredShare{}, fmt.Errorf("several servers announce the code %s, download it with its URL", code)
+		}
+		salts[string(sh.salt)] = true
+		matching = append(matching, sh)
+	}
+
+	for _, sh := range matching {
+
+		// Browsable folders are downloaded as a zip archive, which is only
+		// built for an actual download
+		u := sh.url + url.PathEscape(code)
+		if sh.browse {
+			u += "/"
+		}
+		resp, err := httpClient.Head(u)
+		if err != nil {
+			logrus.Warnf("%s", err)
+			continue
+		}
+		resp.Body.Close()
+		// Only an item actually served matches, refusals and errors do not
+		// tell that the code is right
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			continue
+		}
+
+		if sh.browse {
+			u += "?zip"
+		}
+		sh.url = u
+		return sh, nil
+	}
+	return discoveredShare{}, fmt.Errorf("no share with the code %s found on the local network", code)
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseShare(t *testing.T) {
+	tests := []struct {
+		name   string
+		it     *shareItem
+		browse bool
+		secure bool
+		auth   bool
+		want   discoveredShare
+	}{
+		{
+			name: "file",
+			it:   &shareItem{path: "/home/user/notes.txt", uri: "notes.txt", size: 42},
+			want: discoveredShare{name: "notes.txt", url: "http://10.0.0.2:8080/notes.txt", size: 42, enc: "none"},
+		},
+		{
+			name:   "random uri",
+			it:     &shareItem{path: "/home/user/notes.txt", uri: "sbChTqWQ", size: 42},
+			secure: true,
+			auth:   true,
+			want:   discoveredShare{name: "notes.txt", url: "https://10.0.0.2:8080/sbChTqWQ", size: 42, enc: "none", auth: true},
+		},
+		{
+			name: "folder",
+			it:   &shareItem{path: "/home/user/pics", uri: "pics.tar.gz", folder: true},
+			want: discoveredShare{name: "pics.tar.gz", url: "http://10.0.0.2:8080/pics.tar.gz", size: -1, enc: "none"},
+		},
+		{
+			name:   "browsed folder",
+			it:     &shareItem{path: "/home/user/my pics", uri: "my pics", folder: true},
+			browse: true,
+			want:   discoveredShare{name: "my pics.zip", url: "http://10.0.0.2:8080/my%20pics/?zip", size: -1, enc: "none"},
+		},
+		{
+			name: "word code",
+			it:   &shareItem{path: "/home/user/notes.txt", uri: "7-purple-otter-river", size: 42, code: true},
+			want: discoveredShare{name: "notes.txt", url: "http://10.0.0.2:8080/", size: 42, enc: "none", code: true},
+		},
+		{
+			name:   "browsed folder at a word code",
+			it:     &shareItem{path: "/home/user/pics", uri: "7-purple-otter-river", folder: true, code: true},
+			browse: true,
+			want:   discoveredShare{name: "pics.zip", url: "http://10.0.0.2:8080/", size: -1, enc: "none", code: true, browse: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &session{format: "tgz", browse: tt.browse}
+			txt, err := s.txtRecords(tt.it, tt.secure, tt.auth)
+			if err != nil {
+				t.Fatalf("txtRecords() error = %v", err)
+			}
+			got, ok := parseShare("10.0.0.2:8080", txt)
+			if !ok {
+				t.Fatal("parseShare() failed")
+			}
+
+			// The verifier of the code is only checked, the salt is random
+			if tt.it.code {
+				if !got.matchesCode(tt.it.uri) || got.matchesCode("8-purple-otter-river") {
+					t.Errorf("parseShare() verifier does not match the code only")
+				}
+				got.salt, got.verifier = nil, nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseShare() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	txt, err := (&session{}).txtRecords(&shareItem{path: "/home/user/notes.txt", uri: "7-purple-otter-river", code: true}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, txt := range txt {
+		if strings.Contains(txt, "purple") {
+			t.Errorf("txtRecords() revealed the word code in %s", txt)
+		}
+	}
+	if _, ok := parseShare("10.0.0.2:8080", []string{"name=x", "path=http://elsewhere/"}); ok {
+		t.Error("parseShare() accepted a path that is not absolute")
+	}
+	if _, ok := parseShare("10.0.0.2:8080", []string{"name=x", "code=1"}); ok {
+		t.Error("parseShare() accepted a word code without verifier")
+	}
+}
+
+func Test_pickShare(t *testing.T) {
+	shares := []discoveredShare{
+		{name: "a.txt", url: "http://10.0.0.2:8080/a.txt"},
+		{name: "b.txt", url: "http://10.0.0.2:8080/b.txt"},
+		{name: "b.txt", url: "http://10.0.0.3:8080/b.txt"},
+		{name: "c.txt", url: "http://10.0.0.3:8080/", code: true},
+	}
+	tests := []struct {
+		name    string
+		pick    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "by name", pick: "a.txt", want: "http://10.0.0.2:8080/a.txt"},
+		{name: "unknown name", pick: "d.txt", wantErr: true},
+		{name: "word code", pick: "c.txt", wantErr: true},
+		{name: "ambiguous name", pick: "b.txt", input: "2\n", want: "http://10.0.0.3:8080/b.txt"},
+		{name: "interactive", input: "2\n", want: "http://10.0.0.2:8080/b.txt"},
+		{name: "out of range", input: "4\n", wantErr: true},
+		{name: "no answer", input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickShare(shares, tt.pick, strings.NewReader(tt.input), ioutil.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pickShare() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.url != tt.want {
+				t.Errorf("pickShare() = %s, want %s", got.url, tt.want)
+			}
+		})
+	}
+}
+
+func Test_findCode(t *testing.T) {
+	var asked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked = append(asked, r.URL.Path)
+		switch r.URL.Path {
+		case "/7-purple-otter-river":
+			w.WriteHeader(http.StatusOK)
+		case "/8-amber-otter-river/":
+			w.WriteHeader(http.StatusOK)
+		case "/1-amber-otter-river":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/2-amber-otter-river":
+			w.WriteHeader(http.StatusForbidden)
+		case "/3-amber-otter-river":
+			w.WriteHeader(http.StatusGone)
+		case "/4-amber-otter-river":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// codeShare returns the share at code on srv, as announced
+	codeShare := func(code string, browse bool) discoveredShare {
+		salt := []byte(code)
+		return discoveredShare{name: code, url: srv.URL + "/", code: true, browse: browse, salt: salt, verifier: codeVerifier(code, salt)}
+	}
+	shares := []discoveredShare{{name: "a.txt", url: srv.URL + "/a.txt"}, codeShare("8-amber-otter-river", true)}
+	for _, code := range []string{"7-purple-otter-river", "9-amber-otter-river", "1-amber-otter-river", "2-amber-otter-river", "3-amber-otter-river", "4-amber-otter-river"} {
+		shares = append(shares, codeShare(code, false))
+	}
+	copied := codeShare("5-amber-otter-river", false)
+
+	tests := []struct {
+		name    string
+		shares  []discoveredShare
+		code    string
+		want    string
+		wantErr bool
+		asked   int
+	}{
+		{name: "file", shares: shares, code: "7-purple-otter-river", want: srv.URL + "/7-purple-otter-river", asked: 1},
+		{name: "browsed folder", shares: shares, code: "8-amber-otter-river", want: srv.URL + "/8-amber-otter-river/?zip", asked: 1},
+		{name: "unknown code", shares: shares, code: "9-amber-otter-river", wantErr: true, asked: 1},
+		{name: "unauthorized", shares: shares, code: "1-amber-otter-river", wantErr: true, asked: 1},
+		{name: "forbidden", shares: shares, code: "2-amber-otter-river", wantErr: true, asked: 1},
+		{name: "gone", shares: shares, code: "3-amber-otter-river", wantErr: true, asked: 1},
+		{name: "server error", shares: shares, code: "4-amber-otter-river", wantErr: true, asked: 1},
+		{name: "no verifier", shares: shares, code: "6-amber-otter-river", wantErr: true, asked: 0},
+		{name: "copied verifier", shares: []discoveredShare{copied, copied}, code: "5-amber-otter-river", wantErr: true, asked: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asked = nil
+			got, err := findCode(tt.shares, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findCode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.url != tt.want {
+				t.Errorf("findCode() = %s, want %s", got.url, tt.want)
+			}
+			if len(asked) != tt.asked {
+				t.Errorf("findCode() asked %q, want %d requests", asked, tt.asked)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+)
+
+// untilLayouts are the layouts accepted by --until, in local time unless
+// they carry a time zone. A time of day alone is the next one to come.
+var untilLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"15:04:05",
+	"15:04",
+}
+
+// shareDeadline returns the time at which a share started at now ends, given
+// a duration (--expire) or a timestamp (--until), or the zero time if none is
+// provided.
+func shareDeadline(expire time.Duration, until string, now time.Time) (time.Time, error) {
+	switch {
+	case expire != 0 && until != "":
+		return time.Time{}, fmt.Errorf("cannot use a duration (--expire) and a timestamp (--until) at the same time")
+	case expire < 0:
+		return time.Time{}, fmt.Errorf("the duration of the share (--expire) must be positive")
+	case expire > 0:
+		return now.Add(expire), nil
+	case until == "":
+		return time.Time{}, nil
+	}
+
+	deadline, err := parseUntil(until, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !deadline.After(now) {
+		return time.Time{}, fmt.Errorf("%s is already past", until)
+	}
+	return deadline, nil
+}
+
+// parseUntil parses a timestamp in one of the untilLayouts, relatively to
+// now.
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	for _, layout := range untilLayouts {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err != nil {
+			continue
+		}
+
+		// A time of day alone has no date: take today's, or tomorrow's if
+		// it is already past
+		if t.Year() == 0 {
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+			if !t.After(now) {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %s, expected for example 2006-01-02 15:04 or 15:04", s)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_shareDeadline(t *testing.T) {
+	now := time.Date(2021, 3, 14, 15, 9, 26, 0, time.Local)
+	tests := []struct {
+		name    string
+		expire  time.Duration
+		until   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "none", want: time.Time{}},
+		{name: "duration", expire: 30 * time.Minute, want: now.Add(30 * time.Minute)},
+		{name: "negative duration", expire: -time.Minute, wantErr: true},
+		{name: "both", expire: time.Minute, until: "16:00", wantErr: true},
+		{name: "date and time", until: "2021-03-15 08:00", want: time.Date(2021, 3, 15, 8, 0, 0, 0, time.Local)},
+		{name: "rfc3339", until: "2021-03-14T16:00:00Z", want: time.Date(2021, 3, 14, 16, 0, 0, 0, time.UTC)},
+		{name: "time today", until: "18:30", want: time.Date(2021, 3, 14, 18, 30, 0, 0, time.Local)},
+		{name: "time tomorrow", until: "09:00", want: time.Date(2021, 3, 15, 9, 0, 0, 0, time.Local)},
+		{name: "past", until: "2021-03-14 15:00", wantErr: true},
+		{name: "invalid", until: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shareDeadline(tt.expire, tt.until, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("shareDeadline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("shareDeadline() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,18 @@
 package cmd
 
 import (
-	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/sha256"
-	"encoding/binary"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
@@ -32,63 +29,176 @@ This will create a file called 'out':
 
 This will create a file called new.txt:
   shaloc get -u http://192.168.1.133/file.txt -n new.txt
+
+This will download a shared folder and unpack it in the current folder:
+  shaloc get -u http://192.168.1.133/folder.tar.gz --extract
+
+This will unpack it in the folder 'dest', restoring the permissions:
+  shaloc get -u http://192.168.1.133/folder.zip --extract=dest --permissions
+
+This will download a big file with 4 concurrent connections:
+  shaloc get -u http://192.168.1.133/image.iso --connections 4
+
+This will decrypt file.txt with a key created by 'shaloc keygen':
+  shaloc get -u http://192.168.1.133/file.txt --identity key.txt
+
+This will download file.txt from 'shaloc share --tls', trusting only the
+certificate whose fingerprint it printed:
+  shaloc get -u https://192.168.1.133/file.txt --fingerprint 3f2a...
+
+This will download file.txt from 'shaloc share --basic-auth':
+  shaloc get -u http://192.168.1.133/file.txt --basic-auth user:pass
+
+This will download file.txt from 'shaloc share --advertise', found on the
+local network:
+  shaloc get file.txt
+
+This will download the file shared with 'shaloc share --code' on the local
+network:
+  shaloc get 7-purple-otter-river
+
+This will ask for the code printed by 'shaloc share --pake', and decrypt the
+file with the key it gives:
+  shaloc get -u http://192.168.1.133/file.txt --pake
+
+Without a URL nor a name, the shares found on the local network are listed
+to pick one:
+  shaloc get
 `,
+	Args: cobra.MaximumNArgs(1),
 
 	Run: func(cmd *cobra.Command, args []string) {
 		url, _ := cmd.Flags().GetString("url")
 		output, _ := cmd.Flags().GetString("output")
 		useAES, _ := cmd.Flags().GetBool("aes")
+		identityFiles, _ := cmd.Flags().GetStringArray("identity")
+		usePAKE, _ := cmd.Flags().GetBool("pake")
+		extract, _ := cmd.Flags().GetString("extract")
+		permissions, _ := cmd.Flags().GetBool("permissions")
+		connections, _ := cmd.Flags().GetInt("connections")
+		fingerprint, _ := cmd.Flags().GetString("fingerprint")
+		token, _ := cmd.Flags().GetString("token")
+		basicAuth, _ := cmd.Flags().GetString("basic-auth")
+
+		// If a fingerprint is provided, trust only this certificate. Like the
+		// credentials, it is needed to probe the shares found on the network.
+		if fingerprint != "" {
+			fp, err := parseFingerprint(fingerprint)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			httpClient = pinnedClient(fp)
+		}
+
+		// If credentials are provided, send them with every request
+		if token != "" || basicAuth != "" {
+			c, err := withCredentials(httpClient, token, basicAuth)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			httpClient = c
+		}
 
+		// Without a URL, look for the share on the local network
 		if url == "" {
-			fmt.Println("You must provide a URL with the flag -u !")
+			var name string
+			if len(args) > 0 {
+				name = args[0]
+			}
+			shares, err := discover(discoverTimeout)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+
+			// The name can be the word code of a share, or its file name
+			var sh discoveredShare
+			if code, ok := parseWordCode(name); ok {
+				sh, err = findCode(shares, code)
+			} else {
+				sh, err = pickShare(shares, name, os.Stdin, os.Stdout)
+			}
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+
+			// The announced name comes from the network: it is only used
+			// as a file name in the current folder, without overwriting
+			url = sh.url
+			if output == "" {
+				if output, err = announcedOutput(".", sh.name); err != nil {
+					logrus.Fatalf("%s", err)
+				}
+			}
+			switch {
+			case sh.enc == "aes" && len(identityFiles) == 0:
+				useAES = true
+			case sh.enc == "x25519" && len(identityFiles) == 0:
+				fmt.Println("This share is encrypted to public keys, you must provide your identity with --identity !")
+				os.Exit(1)
+			case sh.enc == "pake":
+				usePAKE = true
+			}
+		} else if len(args) > 0 {
+			fmt.Println("You cannot provide a URL and a name at the same time !")
 			os.Exit(1)
 		}
 
-		// If no output name is provided, take the last part of the URI
+		if usePAKE && (useAES || len(identityFiles) > 0) {
+			fmt.Println("You cannot use a code (--pake) with a passphrase (--aes) or identities (--identity) !")
+			os.Exit(1)
+		}
+
+		// A fingerprint can only be checked on an https URL
+		if fingerprint != "" && !strings.HasPrefix(url, "https://") {
+			fmt.Println("A fingerprint (--fingerprint) can only be checked with an https URL !")
+			os.Exit(1)
+		}
+
+		// If no output name is provided, take the last part of the URI,
+		// without its query
 		if output == "" {
-			parts := strings.SplitAfter(url, "/")
+			uri, _, _ := strings.Cut(url, "?")
+			parts := strings.SplitAfter(uri, "/")
 			output = parts[len(parts)-1]
 		}
 
-		// Ask for the passphrase if needed
-		var bytePassword []byte
-		var err error
-		if useAES {
-			bytePassword, err = askForPass()
+		sec, err := askForSecrets(useAES, identityFiles)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		// With --pake, get the key of the file with a handshake
+		if usePAKE {
+			key, err := askForCode(url)
 			if err != nil {
 				logrus.Fatalf("%s", err)
 			}
+			sec = &secrets{fileKey: key}
 		}
-		err = download(output, url)
+
+		verified, err := download(output, url, sec, connections)
 		if err != nil {
 			logrus.Errorf("%s\n", err)
 			return
 		}
 
 		fmt.Println("Downloaded: " + output + " from " + url)
+		if verified {
+			fmt.Println("Checksum verified.")
+		}
+		if sec != nil {
+			fmt.Printf("Decrypted %s.\n", output)
+		}
 
-		// If --aes, decrypt the file
-		if useAES {
-			// Init and start the spinner
-			s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-			s.Start()
-
-			tmp, err := decryptFile(string(bytePassword), output)
-			if err != nil {
+		// If --extract, unpack the archive and remove it
+		if extract != "" {
+			if err := extractArchive(output, extract, permissions); err != nil {
 				logrus.Fatalf("%s", err)
 			}
-
 			if err := os.Remove(output); err != nil {
-				logrus.Fatalf("%s", err)
+				logrus.Errorf("%s", err)
 			}
-
-			if err := os.Rename(tmp, output); err != nil {
-				logrus.Fatalf("%s", err)
-			}
-
-			s.Stop()
-
-			fmt.Printf("Decrypted %s\n.", output)
+			fmt.Printf("Extracted %s in %s.\n", output, extract)
 		}
 	},
 }
@@ -98,82 +208,285 @@ func init() {
 	getCmd.Flags().StringP("url", "u", "", "URL to download the file from.")
 	getCmd.Flags().StringP("output", "o", "", "Name of the file that will be downloaded.")
 	getCmd.Flags().Bool("aes", false, "Use AES-256 decryption.")
+	getCmd.Flags().String("extract", "", "Extract the downloaded archive (zip, tar, tar.gz or tar.zst) in the given folder, or in the current one.")
+	getCmd.Flags().Lookup("extract").NoOptDefVal = "."
+	getCmd.Flags().Bool("permissions", false, "Restore the permissions and the modification times of the extracted files.")
+	getCmd.Flags().Int("connections", 1, "Number of concurrent connections used to download the file, if the server supports ranges.")
+	getCmd.Flags().Bool("pake", false, "Ask for the code printed by 'shaloc share --pake', to get the key of the file with a handshake.")
+	getCmd.Flags().StringArray("identity", nil, "Identity file created by 'shaloc keygen' to decrypt the file with. Can be repeated.")
+	getCmd.Flags().String("fingerprint", "", "SHA-256 fingerprint of the TLS certificate of the server, printed by 'shaloc share --tls'.")
+	getCmd.Flags().String("token", "", "Token to send as a bearer token, if it is not in the URL.")
+	getCmd.Flags().String("basic-auth", "", "Credentials to authenticate with, in the user:pass form.")
 }
 
-// download downloads a file from url and write it in filepath
-func download(filepath string, url string) error {
+// httpClient sends the requests of the downloads. get replaces it to pin the
+// certificate of the server.
+var httpClient = http.DefaultClient
 
-	// Init and start the spinner
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Start()
+// downloadRetries is the number of times an interrupted download is resumed
+// before giving up.
+const downloadRetries = 5
 
-	// Get the data from the url
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// download downloads a file from url and write it in filepath. If sec is not
+// nil, the file is decrypted on the fly with it. If the connection drops, the
+// download is resumed where it stopped when the server supports it, or
+// restarted otherwise. Plain files can be downloaded with several concurrent
+// connections. The file is verified against the checksums published by the
+// server, and removed if they do not match; download reports whether a
+// checksum was verified.
+func download(filepath string, url string, sec *secrets, connections int) (bool, error) {
 
 	// Create the file
 	out, err := os.Create(filepath)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer out.Close()
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+	// Report the progress of the download, until it is verified
+	progress := newProgressBar(path.Base(filepath))
+	progress.Start()
+	defer progress.Stop()
+
+	// All the requests of the download carry the same id, so that the server
+	// counts them as a single download
+	id, err := newDownloadID()
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		parallel bool
+		verified bool
+		sums     http.Header
+	)
+	if connections > 1 && sec == nil {
+		if parallel, sums, err = downloadParallel(out, url, id, connections, progress); err != nil {
+			return false, err
+		}
+		if !parallel {
+			logrus.Infof("The server does not support ranges, downloading with a single connection")
+		}
+	}
 
-	// Stop the spinner
-	s.Stop()
+	if !parallel {
+		d := &downloader{url: url, id: id, out: out, sec: sec, progress: progress}
+		for attempt := 1; ; attempt++ {
+			err = d.fetch()
+			if _, ok := err.(*interruptedError); !ok || attempt > downloadRetries {
+				break
+			}
 
-	return err
+			delay := time.Duration(attempt) * time.Second
+			logrus.Warnf("%s, resuming in %s", err, delay)
+			time.Sleep(delay)
+		}
+		sums, verified = d.sums, d.verified
+	}
+
+	if err == nil && sums != nil {
+		verified, err = verifyFile(out, sums)
+	}
+
+	// Do not leave a partially decrypted or a corrupted file behind
+	if err != nil && (sec != nil || isChecksumError(err)) {
+		out.Close()
+		os.Remove(filepath)
+	}
+	return verified, err
 }
 
-// decryptFile decrypts filename with the key p using AES-256
-func decryptFile(p, filename string) (string, error) {
-	outFilename := filename + ".dec"
+// verifyFile compares the content of f to the checksums found in headers.
+func verifyFile(f *os.File, headers http.Header) (bool, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	hw := newHashWriter(newHashes(headers.Get(blake3Header) != ""))
+	if _, err := io.Copy(hw, f); err != nil {
+		return false, err
+	}
+	return verifyChecksums(headers, hw)
+}
+
+// downloader writes the content of url in out, across as many requests as
+// needed.
+type downloader struct {
+	url      string
+	id       string
+	out      *os.File
+	sec      *secrets
+	progress *progress
+
+	// written is the number of bytes already in out, and validator the ETag
+	// or the Last-Modified date of the content they come from.
+	written   int64
+	validator string
+
+	// sums holds the checksums of the whole file sent in the headers, to
+	// verify it once complete. Those sent in trailers only describe a
+	// response, and are verified right away.
+	sums     http.Header
+	verified bool
+}
 
-	key := sha256.Sum256([]byte(p))
-	ciphertext, err := ioutil.ReadFile(filename)
+// announcedOutput creates the file to download the share announced as name
+// in dir, and returns its name. name comes from the network: only a sanitized
+// base name is kept, and existing files are never overwritten.
+func announcedOutput(dir, name string) (string, error) {
+	clean := sanitizeFilename(name)
+	if clean == "" {
+		return "", fmt.Errorf("invalid name %q announced, choose the output with -o", name)
+	}
+	f, unique, err := createUnique(dir, clean)
 	if err != nil {
 		return "", err
 	}
+	f.Close()
+	return filepath.Join(dir, unique), nil
+}
 
-	of, err := os.Create(outFilename)
-	if err != nil {
+// newDownloadID returns a random id for the requests of a download.
+func newDownloadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	defer of.Close()
+	return hex.EncodeToString(b), nil
+}
 
-	// cipertext has the original plaintext size in the first 8 bytes, then IV
-	// in the next 16 bytes, then the actual ciphertext in the rest of the buffer.
-	// Read the original plaintext size, and the IV.
-	var origSize uint64
-	buf := bytes.NewReader(ciphertext)
-	if err = binary.Read(buf, binary.LittleEndian, &origSize); err != nil {
-		return "", err
+// interruptedError is returned when the connection to the server is lost.
+type interruptedError struct {
+	err error
+}
+
+func (e *interruptedError) Error() string {
+	return "download interrupted: " + e.err.Error()
+}
+
+// interruptReader turns the errors of r into interruptedError, so that they
+// can be told apart from decryption and write errors.
+type interruptReader struct {
+	r io.Reader
+}
+
+func (ir *interruptReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &interruptedError{err}
+	}
+	return n, err
+}
+
+// fetch sends a request for what is missing in d.out, and writes the response
+// in it. Encrypted downloads are always restarted from the beginning.
+func (d *downloader) fetch() error {
+	req, err := http.NewRequest(http.MethodGet, d.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(downloadIDHeader, d.id)
+	if d.written > 0 && d.sec == nil && d.validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.written))
+		req.Header.Set("If-Range", d.validator)
 	}
-	iv := make([]byte, aes.BlockSize)
-	if _, err = buf.Read(iv); err != nil {
+
+	resp, err := httpClient.Do(req)
+	if isCertificateError(err) {
+		return err
+	} else if err != nil {
+		return &interruptedError{err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != d.written {
+			return fmt.Errorf("unexpected range %q in the response", resp.Header.Get("Content-Range"))
+		}
+		if resp.ContentLength >= 0 {
+			d.progress.setTotal(d.written + resp.ContentLength)
+		}
+	case http.StatusOK:
+		// The whole content is sent again, because the server does not
+		// support ranges or because it changed.
+		if err := d.restart(); err != nil {
+			return err
+		}
+		d.progress.setTotal(resp.ContentLength)
+	default:
+		return fmt.Errorf("cannot download %s: %s", d.url, resp.Status)
+	}
+
+	// Resuming is only safe with a strong ETag, or a modification date
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		d.validator = etag
+	} else {
+		d.validator = resp.Header.Get("Last-Modified")
+	}
+
+	d.sums = checksumHeaders(resp.Header)
+	hw := newHashWriter(newHashes(true))
+
+	var body io.Reader = io.TeeReader(&interruptReader{resp.Body}, io.MultiWriter(hw, d.progress))
+	if d.sec != nil {
+		body, err = newDecryptReader(body, d.sec)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := io.Copy(d.out, body)
+	d.written += n
+	if err != nil {
+		return err
+	}
+
+	// Trailers are only available once the body is read
+	if resp.StatusCode == http.StatusOK {
+		d.verified, err = verifyChecksums(resp.Trailer, hw)
+	}
+	return err
+}
+
+// restart empties d.out, and resets the progress.
+func (d *downloader) restart() error {
+	d.progress.reset(0)
+	if d.written == 0 {
+		return nil
+	}
+	d.written = 0
+	if err := d.out.Truncate(0); err != nil {
+		return err
+	}
+	_, err := d.out.Seek(0, io.SeekStart)
+	return err
+}
+
+// decryptFile decrypts filename with sec and writes the result in
+// filename.dec. Nothing is left behind if the file cannot be authenticated.
+func decryptFile(sec *secrets, filename string) (string, error) {
+	outFilename := filename + ".dec"
+
+	in, err := os.Open(filename)
+	if err != nil {
 		return "", err
 	}
+	defer in.Close()
 
-	// The remaining ciphertext has size=paddedSize.
-	paddedSize := len(ciphertext) - 8 - aes.BlockSize
-	if paddedSize%aes.BlockSize != 0 {
-		return "", fmt.Errorf("want padded plaintext size to be aligned to block size")
+	plaintext, err := newDecryptReader(in, sec)
+	if err != nil {
+		return "", err
 	}
-	plaintext := make([]byte, paddedSize)
 
-	block, err := aes.NewCipher(key[:])
+	of, err := os.Create(outFilename)
 	if err != nil {
 		return "", err
 	}
-	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(plaintext, ciphertext[8+aes.BlockSize:])
+	defer of.Close()
 
-	if _, err := of.Write(plaintext[:origSize]); err != nil {
+	if _, err := io.Copy(of, plaintext); err != nil {
+		os.Remove(outFilename)
 		return "", err
 	}
 	return outFilename, nil
@@ -198,5 +511,51 @@ func askForPass() ([]byte, error) {
 		return nil, fmt.Errorf("passwords do not match")
 	}
 
+	if len(try) == 0 {
+		return nil, fmt.Errorf("password cannot be empty")
+	}
+
 	return try, nil
 }
+
+// askForCode asks for the code of a share started with --pake, and returns the
+// key of the file given by the server of url. Word codes may be typed with
+// spaces and capital letters.
+func askForCode(url string) ([]byte, error) {
+	fmt.Println("Type the code given by the sender:")
+	code, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := parseWordCode(string(code)); ok {
+		code = []byte(c)
+	}
+	return pakeHandshake(url, code)
+}
+
+// askForSecrets asks for a passphrase if useAES is set, and loads the
+// identities from identityFiles. It returns nil if there is nothing to
+// decrypt with.
+func askForSecrets(useAES bool, identityFiles []string) (*secrets, error) {
+	if !useAES && len(identityFiles) == 0 {
+		return nil, nil
+	}
+
+	sec := &secrets{}
+	for _, f := range identityFiles {
+		ids, err := readIdentities(f)
+		if err != nil {
+			return nil, err
+		}
+		sec.identities = append(sec.identities, ids...)
+	}
+
+	if useAES {
+		p, err := askForPass()
+		if err != nil {
+			return nil, err
+		}
+		sec.passphrase = p
+	}
+	return sec, nil
+}
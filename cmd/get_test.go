@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_download_resume(t *testing.T) {
+	content := bytes.Repeat([]byte("shaloc"), 100000)
+	modTime := time.Now()
+
+	var ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+
+		// Drop the connection in the middle of the first response
+		if len(ranges) == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/3])
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+			return
+		}
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	if _, err := download(out, srv.URL, nil, 1); err != nil {
+		t.Fatalf("download() error = %v", err)
+	}
+
+	got, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("download() wrote %d bytes, want %d", len(got), len(content))
+	}
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != "bytes="+strconv.Itoa(len(content)/3)+"-" {
+		t.Errorf("download() sent ranges %q", ranges)
+	}
+}
+
+func Test_sentAll(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentRange string
+		length       string
+		written      int64
+		want         bool
+	}{
+		{name: "full", status: http.StatusOK, length: "100", written: 100, want: true},
+		{name: "aborted", status: http.StatusOK, length: "100", written: 42, want: false},
+		{name: "last range", status: http.StatusPartialContent, contentRange: "bytes 10-99/100", length: "90", written: 90, want: true},
+		{name: "first range", status: http.StatusPartialContent, contentRange: "bytes 0-9/100", length: "10", written: 10, want: true},
+		{name: "aborted range", status: http.StatusPartialContent, contentRange: "bytes 0-9/100", length: "10", written: 5, want: false},
+		{name: "not modified", status: http.StatusNotModified, want: false},
+		{name: "unsatisfiable", status: http.StatusRequestedRangeNotSatisfiable, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rec.Header().Set("Content-Range", tt.contentRange)
+			rec.Header().Set("Content-Length", tt.length)
+			if got := sentAll(&statusWriter{ResponseWriter: rec, status: tt.status, written: tt.written}); got != tt.want {
+				t.Errorf("sentAll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_download_parallel(t *testing.T) {
+	content := make([]byte, 5*minPartSize+123)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	modTime := time.Now()
+
+	tests := []struct {
+		name    string
+		ranges  bool
+		wantReq int
+	}{
+		{name: "ranges", ranges: true, wantReq: 1 + 4 + 1},
+		{name: "no ranges", ranges: false, wantReq: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Header.Get("Range"))
+				mu.Unlock()
+
+				if !tt.ranges {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					w.Write(content)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(content))
+			}))
+			defer srv.Close()
+
+			dir, err := ioutil.TempDir("", "shaloc-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			out := filepath.Join(dir, "out")
+			if _, err := download(out, srv.URL, nil, 4); err != nil {
+				t.Fatalf("download() error = %v", err)
+			}
+
+			got, err := ioutil.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("download() wrote %d different bytes, want %d", len(got), len(content))
+			}
+			if len(requests) != tt.wantReq {
+				t.Errorf("download() sent %d requests, want %d: %q", len(requests), tt.wantReq, requests)
+			}
+			if last := fmt.Sprintf("bytes=%d-%d", len(content)-1, len(content)-1); tt.ranges && requests[len(requests)-1] != last {
+				t.Errorf("download() ended with range %q, want %q", requests[len(requests)-1], last)
+			}
+		})
+	}
+}
+
+func Test_splitRange(t *testing.T) {
+	tests := []struct {
+		name string
+		r    byteRange
+		n    int
+		want []byteRange
+	}{
+		{name: "even", r: byteRange{0, 4*minPartSize - 1}, n: 2, want: []byteRange{{0, 2*minPartSize - 1}, {2 * minPartSize, 4*minPartSize - 1}}},
+		{name: "remainder", r: byteRange{0, 2 * minPartSize}, n: 2, want: []byteRange{{0, minPartSize - 1}, {minPartSize, 2 * minPartSize}}},
+		{name: "too small", r: byteRange{0, 2*minPartSize - 1}, n: 8, want: []byteRange{{0, minPartSize - 1}, {minPartSize, 2*minPartSize - 1}}},
+		{name: "tiny", r: byteRange{0, 9}, n: 4, want: []byteRange{{0, 9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitRange(tt.r, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_download_checksum(t *testing.T) {
+	content := []byte("shaloc")
+	hw := newHashWriter(newHashes(false))
+	hw.Write(content)
+	sum := hw.sums()[sha256Header]
+
+	tests := []struct {
+		name     string
+		sum      string
+		trailer  bool
+		wantErr  bool
+		wantFile bool
+	}{
+		{name: "header", sum: sum, wantFile: true},
+		{name: "wrong header", sum: strings.Repeat("0", 64), wantErr: true},
+		{name: "trailer", sum: sum, trailer: true, wantFile: true},
+		{name: "wrong trailer", sum: strings.Repeat("0", 64), trailer: true, wantErr: true},
+		{name: "missing trailer", trailer: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.trailer {
+					w.Header().Set("Trailer", sha256Header)
+					w.Write(content)
+					if tt.sum != "" {
+						w.Header().Set(sha256Header, tt.sum)
+					}
+					return
+				}
+				w.Header().Set(sha256Header, tt.sum)
+				w.Write(content)
+			}))
+			defer srv.Close()
+
+			dir, err := ioutil.TempDir("", "shaloc-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			out := filepath.Join(dir, "out")
+			verified, err := download(out, srv.URL, nil, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if verified != !tt.wantErr {
+				t.Errorf("download() verified = %v", verified)
+			}
+			if _, err := os.Stat(out); (err == nil) != tt.wantFile {
+				t.Errorf("download() left file = %v, want %v", err == nil, tt.wantFile)
+			}
+		})
+	}
+}
+
+func Test_announcedOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{".bashrc", "bashrc", "notes.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("mine"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "new", in: "pics.zip", want: "pics.zip"},
+		{name: "existing", in: "notes.txt", want: "notes-2.txt"},
+		{name: "hidden", in: ".bashrc", want: "bashrc-2"},
+		{name: "path", in: "../../.bashrc", want: "bashrc-3"},
+		{name: "nothing left", in: "..", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := announcedOutput(dir, tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("announcedOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != filepath.Join(dir, tt.want) {
+				t.Errorf("announcedOutput() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Existing files are left untouched
+	for _, name := range []string{".bashrc", "notes.txt"} {
+		if b, _ := ioutil.ReadFile(filepath.Join(dir, name)); string(b) != "mine" {
+			t.Errorf("%s was overwritten", name)
+		}
+	}
+}
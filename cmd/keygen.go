@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Prefixes of the text representation of X25519 keys. Secret keys are upper
+// case and public keys lower case, so that they cannot be mistaken.
+const (
+	publicKeyPrefix = "shaloc1"
+	secretKeyPrefix = "SHALOC-SECRET-KEY-1"
+)
+
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// keygenCmd represents the keygen command
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate an identity to receive encrypted files",
+	Long: `keygen generates an X25519 identity. Its public key can be given to the people
+that will share files with you, so that they can encrypt them without having to
+agree on a passphrase. For example:
+
+This will write a new identity in key.txt and print its public key:
+  shaloc keygen -o key.txt
+
+Then, the sender can encrypt a file for you with:
+  shaloc share -f file.txt --recipient shaloc1...
+
+And you can download and decrypt it with:
+  shaloc get -u http://192.168.1.36:8080/file.txt --identity key.txt
+`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		var identity, public [32]byte
+		if _, err := io.ReadFull(rand.Reader, identity[:]); err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		curve25519.ScalarBaseMult(&public, &identity)
+
+		content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n",
+			time.Now().Format(time.RFC3339), encodePublicKey(public), encodeSecretKey(identity))
+
+		if output == "" {
+			fmt.Print(content)
+			return
+		}
+
+		// Never overwrite an existing identity, and keep it private
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		defer f.Close()
+
+		if _, err := f.WriteString(content); err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		fmt.Printf("Public key: %s\n", encodePublicKey(public))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+	keygenCmd.Flags().StringP("output", "o", "", "File to write the identity in. It is printed if empty.")
+}
+
+func encodePublicKey(k [32]byte) string {
+	return publicKeyPrefix + strings.ToLower(keyEncoding.EncodeToString(k[:]))
+}
+
+func encodeSecretKey(k [32]byte) string {
+	return secretKeyPrefix + keyEncoding.EncodeToString(k[:])
+}
+
+// decodeKey decodes the text representation of a key, without its prefix.
+func decodeKey(s string) ([32]byte, error) {
+	var k [32]byte
+	b, err := keyEncoding.DecodeString(strings.ToUpper(s))
+	if err != nil || len(b) != len(k) {
+		return k, fmt.Errorf("malformed key")
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// parseRecipients returns the public keys designated by r, which is either a
+// public key or a file containing one key per line. Secret keys found in such a
+// file are converted to their public key, so that one can encrypt for oneself.
+func parseRecipients(r string) ([][32]byte, error) {
+	if strings.HasPrefix(r, publicKeyPrefix) {
+		k, err := decodeKey(strings.TrimPrefix(r, publicKeyPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %s: %s", r, err)
+		}
+		return [][32]byte{k}, nil
+	}
+
+	lines, err := readKeyFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys [][32]byte
+	for _, l := range lines {
+		switch {
+		case strings.HasPrefix(l, publicKeyPrefix):
+			k, err := decodeKey(strings.TrimPrefix(l, publicKeyPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient in %s: %s", r, err)
+			}
+			keys = append(keys, k)
+		case strings.HasPrefix(l, secretKeyPrefix):
+			id, err := decodeKey(strings.TrimPrefix(l, secretKeyPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid identity in %s: %s", r, err)
+			}
+			var k [32]byte
+			curve25519.ScalarBaseMult(&k, &id)
+			keys = append(keys, k)
+		default:
+			return nil, fmt.Errorf("%s is neither a public key nor a file of public keys", r)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key found in %s", r)
+	}
+	return keys, nil
+}
+
+// readIdentities returns the secret keys stored in the identity file f.
+func readIdentities(f string) ([][32]byte, error) {
+	lines, err := readKeyFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids [][32]byte
+	for _, l := range lines {
+		if !strings.HasPrefix(l, secretKeyPrefix) {
+			return nil, fmt.Errorf("%s is not an identity file", f)
+		}
+		id, err := decodeKey(strings.TrimPrefix(l, secretKeyPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity in %s: %s", f, err)
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no identity found in %s", f)
+	}
+	return ids, nil
+}
+
+// readKeyFile returns the lines of name, without comments and blank lines.
+func readKeyFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, sc.Err()
+}
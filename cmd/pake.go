@@ -0,0 +1,368 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"filippo.io/edwards25519"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Shares started with --pake encrypt their content with a random file key,
+// which is handed to the receivers that know a short code with a SPAKE2
+// handshake over edwards25519:
+//
+//	get    -> share   X = x·G + w·M
+//	share  -> get     Y = y·G + w·N, handshake id
+//	get    -> share   handshake id, confirmation
+//	share  -> get     file key sealed with the session key
+//
+// where w is derived from the code, and M and N are points nobody knows the
+// discrete logarithm of. Both sides compute K = 8·x·(Y - w·N) = 8·y·(X - w·M)
+// if they use the same code. An eavesdropper cannot test codes offline, and an
+// active attacker can only test one code per handshake: share only answers a
+// right confirmation, and stops after pakeMaxFailures wrong ones.
+const (
+	// pakePath is where share runs the handshakes. No item can be shared
+	// at it with --pake.
+	pakePath = "/.shaloc-pake"
+	pakeInfo = "shaloc-pake"
+
+	// pakeCodeWords is the number of words of the generated codes.
+	pakeCodeWords = 2
+	// pakeTimeout is how long a started handshake can be confirmed.
+	pakeTimeout = time.Minute
+	// pakeMaxFailures is the number of wrong codes after which a share stops.
+	pakeMaxFailures = 3
+	// pakeMaxPending is the number of handshakes that can wait for their
+	// confirmation at the same time.
+	pakeMaxPending = 64
+
+	pakeMessageSize = 32
+	handshakeIDSize = 16
+)
+
+var (
+	pakeM = pakePoint("M")
+	pakeN = pakePoint("N")
+)
+
+var (
+	// errWrongCode is returned by pakeHandshake when the server refuses the
+	// code.
+	errWrongCode = errors.New("wrong code")
+	// errTooManyHandshakes is returned by pakeServer.start when too many
+	// handshakes are pending.
+	errTooManyHandshakes = errors.New("too many handshakes in progress")
+)
+
+// pakePoint derives a point of the prime order subgroup from seed, by hashing
+// it until it is the encoding of a point, so that its discrete logarithm is
+// unknown.
+func pakePoint(seed string) *edwards25519.Point {
+	for i := 0; ; i++ {
+		h := sha256.Sum256([]byte(fmt.Sprintf("%s %s %d", pakeInfo, seed, i)))
+		p, err := new(edwards25519.Point).SetBytes(h[:])
+		if err != nil {
+			continue
+		}
+		p.MultByCofactor(p)
+		if p.Equal(edwards25519.NewIdentityPoint()) == 1 {
+			continue
+		}
+		return p
+	}
+}
+
+// pakePassword derives the scalar w from code.
+func pakePassword(code []byte) *edwards25519.Scalar {
+	h := sha512.Sum512(append([]byte(pakeInfo+" password "), code...))
+	w, _ := edwards25519.NewScalar().SetUniformBytes(h[:])
+	return w
+}
+
+// pakeMessage returns a random secret scalar x, and the message x·G + w·blind
+// to send to the other side.
+func pakeMessage(w *edwards25519.Scalar, blind *edwards25519.Point) (*edwards25519.Scalar, []byte, error) {
+	var b [64]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return nil, nil, err
+	}
+	x, err := edwards25519.NewScalar().SetUniformBytes(b[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := new(edwards25519.Point).ScalarBaseMult(x)
+	m.Add(m, new(edwards25519.Point).ScalarMult(w, blind))
+	return x, m.Bytes(), nil
+}
+
+// pakeSecret returns the shared point 8·x·(peer - w·blind), where peer is the
+// message of the other side and blind the point it used.
+func pakeSecret(x, w *edwards25519.Scalar, peer []byte, blind *edwards25519.Point) ([]byte, error) {
+	p, err := new(edwards25519.Point).SetBytes(peer)
+	if err != nil {
+		return nil, errors.New("invalid handshake message")
+	}
+
+	p.Subtract(p, new(edwards25519.Point).ScalarMult(w, blind))
+	p.ScalarMult(x, p)
+	p.MultByCofactor(p)
+	if p.Equal(edwards25519.NewIdentityPoint()) == 1 {
+		return nil, errors.New("invalid handshake message")
+	}
+	return p.Bytes(), nil
+}
+
+// pakeKeys derives from the transcript of a handshake the key confirming that
+// the client knows the code, and the key sealing the file key.
+func pakeKeys(x, y, k []byte, w *edwards25519.Scalar) (confirm, session []byte, err error) {
+	transcript := sha256.New()
+	for _, b := range [][]byte{x, y, k, w.Bytes()} {
+		transcript.Write(b)
+	}
+	secret := transcript.Sum(nil)
+
+	confirm = make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(pakeInfo+" confirm")), confirm); err != nil {
+		return nil, nil, err
+	}
+	session = make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(pakeInfo+" key")), session); err != nil {
+		return nil, nil, err
+	}
+	return confirm, session, nil
+}
+
+// pakeConfirmation returns the confirmation sent by the client.
+func pakeConfirmation(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(pakeInfo + " client"))
+	return mac.Sum(nil)
+}
+
+// pakeServer runs the handshakes of a share, and gives its file key to the
+// clients that know the code. locked is closed once too many wrong codes were
+// tried.
+type pakeServer struct {
+	w       *edwards25519.Scalar
+	fileKey []byte
+
+	mu       sync.Mutex
+	pending  map[string]*pendingHandshake
+	failures int
+	locked   chan struct{}
+}
+
+// pendingHandshake is a handshake waiting for the confirmation of the client.
+type pendingHandshake struct {
+	confirm []byte
+	session []byte
+	expires time.Time
+}
+
+// newPAKEServer returns a pakeServer giving fileKey to the clients knowing
+// code.
+func newPAKEServer(code string, fileKey []byte) *pakeServer {
+	return &pakeServer{
+		w:       pakePassword([]byte(code)),
+		fileKey: fileKey,
+		pending: map[string]*pendingHandshake{},
+		locked:  make(chan struct{}),
+	}
+}
+
+// ServeHTTP starts a handshake when the body is the message of the client, and
+// finishes it when the body is a handshake id followed by a confirmation.
+func (p *pakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	select {
+	case <-p.locked:
+		http.Error(w, "too many wrong codes", http.StatusForbidden)
+		return
+	default:
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, handshakeIDSize+sha256.Size+1))
+	if err != nil {
+		return
+	}
+	switch len(body) {
+	case pakeMessageSize:
+		resp, err := p.start(body)
+		if err == errTooManyHandshakes {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(resp)
+	case handshakeIDSize + sha256.Size:
+		sealed, err := p.finish(body[:handshakeIDSize], body[handshakeIDSize:], r.RemoteAddr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.Write(sealed)
+	default:
+		http.Error(w, "invalid handshake message", http.StatusBadRequest)
+	}
+}
+
+// start answers the message x of a client with the message of the server and
+// the id of the handshake.
+func (p *pakeServer) start(x []byte) ([]byte, error) {
+	secret, y, err := pakeMessage(p.w, pakeN)
+	if err != nil {
+		return nil, err
+	}
+	k, err := pakeSecret(secret, p.w, x, pakeM)
+	if err != nil {
+		return nil, err
+	}
+	confirm, session, err := pakeKeys(x, y, k, p.w)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, handshakeIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for k, h := range p.pending {
+		if now.After(h.expires) {
+			delete(p.pending, k)
+		}
+	}
+	if len(p.pending) >= pakeMaxPending {
+		return nil, errTooManyHandshakes
+	}
+	p.pending[hex.EncodeToString(id)] = &pendingHandshake{confirm: confirm, session: session, expires: now.Add(pakeTimeout)}
+	return append(y, id...), nil
+}
+
+// finish checks the confirmation of the handshake id, sent by the client at
+// addr, and returns the file key sealed with the session key. A handshake can
+// only be finished once.
+func (p *pakeServer) finish(id, confirmation []byte, addr string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.pending[hex.EncodeToString(id)]
+	if !ok || time.Now().After(h.expires) {
+		return nil, errors.New("unknown handshake")
+	}
+	delete(p.pending, hex.EncodeToString(id))
+
+	if !hmac.Equal(confirmation, pakeConfirmation(h.confirm)) {
+		p.failures++
+		logrus.Warnf("Wrong code from %s (%d/%d)", addr, p.failures, pakeMaxFailures)
+		if p.failures == pakeMaxFailures {
+			close(p.locked)
+		}
+		return nil, errWrongCode
+	}
+
+	aead, err := chacha20poly1305.New(h.session)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each session key is used only once, so the nonce can be constant.
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), p.fileKey, nil), nil
+}
+
+// pakeHandshake runs a handshake with the server of rawURL, and returns the
+// file key of the share if code is right.
+func pakeHandshake(rawURL string, code []byte) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path, u.RawPath = pakePath, ""
+
+	w := pakePassword(code)
+	x, msg, err := pakeMessage(w, pakeM)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := pakePost(u.String(), msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) != pakeMessageSize+handshakeIDSize {
+		return nil, errors.New("invalid handshake answer")
+	}
+	y, id := resp[:pakeMessageSize], resp[pakeMessageSize:]
+
+	k, err := pakeSecret(x, w, y, pakeN)
+	if err != nil {
+		return nil, err
+	}
+	confirm, session, err := pakeKeys(msg, y, k, w)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := pakePost(u.String(), append(append([]byte{}, id...), pakeConfirmation(confirm)...))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.New(session)
+	if err != nil {
+		return nil, err
+	}
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, nil)
+	if err != nil || len(key) != keySize {
+		return nil, errors.New("the server does not know the code")
+	}
+	return key, nil
+}
+
+// pakePost sends body to the handshake URL u, and returns the answer.
+func pakePost(u string, body []byte) ([]byte, error) {
+	resp, err := httpClient.Post(u, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, errors.New("this share does not use a code (--pake)")
+	case http.StatusForbidden:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 128))
+		if m := string(bytes.TrimSpace(msg)); m != errWrongCode.Error() {
+			return nil, errors.New(m)
+		}
+		return nil, errWrongCode
+	default:
+		return nil, fmt.Errorf("handshake failed: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, keySize+chacha20poly1305.Overhead+pakeMessageSize+handshakeIDSize))
+}
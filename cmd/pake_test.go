@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_pakeHandshake(t *testing.T) {
+	enc, key, err := newPAKEEncryptor()
+	if err != nil {
+		t.Fatalf("newPAKEEncryptor() error = %v", err)
+	}
+	p := newPAKEServer("42-otter-river", key)
+	mux := http.NewServeMux()
+	mux.Handle(pakePath, p)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	got, err := pakeHandshake(srv.URL+"/notes.txt?token=abc", []byte("42-otter-river"))
+	if err != nil {
+		t.Fatalf("pakeHandshake() error = %v", err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("pakeHandshake() = %x, want %x", got, key)
+	}
+
+	// The key decrypts the content of the share
+	var buf bytes.Buffer
+	ew, err := enc.newWriter(&buf)
+	if err != nil {
+		t.Fatalf("newWriter() error = %v", err)
+	}
+	plaintext := []byte("SHAre files LOCally !")
+	ew.Write(plaintext)
+	ew.Close()
+	r, err := newDecryptReader(bytes.NewReader(buf.Bytes()), &secrets{fileKey: got})
+	if err != nil {
+		t.Fatalf("newDecryptReader() error = %v", err)
+	}
+	if out, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(out, plaintext) {
+		t.Errorf("newDecryptReader() = %q, %v, want %q", out, err, plaintext)
+	}
+	if _, err := newDecryptReader(bytes.NewReader(buf.Bytes()), &secrets{passphrase: []byte("42-otter-river")}); err == nil {
+		t.Error("newDecryptReader() decrypted with the code as passphrase")
+	}
+
+	// Wrong codes are refused, and lock the share after pakeMaxFailures
+	for i := 0; i < pakeMaxFailures; i++ {
+		if _, err := pakeHandshake(srv.URL, []byte("43-otter-river")); err != errWrongCode {
+			t.Fatalf("pakeHandshake() error = %v, want %v", err, errWrongCode)
+		}
+	}
+	select {
+	case <-p.locked:
+	default:
+		t.Fatal("pakeServer not locked after too many wrong codes")
+	}
+	if _, err := pakeHandshake(srv.URL, []byte("42-otter-river")); err == nil {
+		t.Error("pakeHandshake() succeeded on a locked share")
+	}
+}
+
+func Test_pakeServer_finish(t *testing.T) {
+	p := newPAKEServer("42-otter-river", make([]byte, keySize))
+	w := pakePassword([]byte("42-otter-river"))
+	x, msg, err := pakeMessage(w, pakeM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := p.start(msg)
+	if err != nil {
+		t.Fatalf("start() error = %v", err)
+	}
+	y, id := resp[:pakeMessageSize], resp[pakeMessageSize:]
+	k, err := pakeSecret(x, w, y, pakeN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, _, err := pakeKeys(msg, y, k, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.finish(id, pakeConfirmation(confirm), "test"); err != nil {
+		t.Fatalf("finish() error = %v", err)
+	}
+	if _, err := p.finish(id, pakeConfirmation(confirm), "test"); err == nil {
+		t.Error("finish() accepted a handshake twice")
+	}
+	if p.failures != 0 {
+		t.Errorf("finish() counted %d failures, want 0", p.failures)
+	}
+
+	// Handshakes are refused while too many are pending
+	for i := 0; i < pakeMaxPending; i++ {
+		if _, err := p.start(msg); err != nil {
+			t.Fatalf("start() error = %v", err)
+		}
+	}
+	if _, err := p.start(msg); err != errTooManyHandshakes {
+		t.Errorf("start() error = %v, want %v", err, errTooManyHandshakes)
+	}
+
+	// Messages that are not points are refused
+	invalid := make([]byte, pakeMessageSize)
+	invalid[0] = 2
+	if _, err := p.start(invalid); err == nil {
+		t.Error("start() accepted an invalid point")
+	}
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minPartSize is the smallest range fetched by a connection of a parallel
+// download. Smaller files are downloaded with a single connection.
+const minPartSize = 1 << 20
+
+// byteRange is an inclusive range of bytes of a file.
+type byteRange struct {
+	start, end int64
+}
+
+// downloadParallel downloads url in out with up to connections concurrent
+// range requests, all carrying the download id, and returns the checksums announced by the server. It
+// returns false, without writing anything, if the server cannot serve ranges
+// of a stable content; the caller should then fall back to a single stream.
+func downloadParallel(out *os.File, url, id string, connections int, progress *progress) (bool, http.Header, error) {
+	resp, err := httpClient.Head(url)
+	if err != nil {
+		return false, nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, nil, fmt.Errorf("cannot download %s: %s", url, resp.Status)
+	}
+
+	// Ranges of different versions of the file must not be mixed, so a strong
+	// ETag or a modification date is needed.
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	size := resp.ContentLength
+	if resp.Header.Get("Accept-Ranges") != "bytes" || validator == "" || size < 2*minPartSize {
+		return false, nil, nil
+	}
+
+	if err := out.Truncate(size); err != nil {
+		return true, nil, err
+	}
+	progress.setTotal(size)
+	pd := &parallelDownload{url: url, id: id, validator: validator, size: size, out: out, progress: progress}
+
+	// The last byte is fetched once everything else is there: the server
+	// counts a download when its end is sent, and could stop serving the file
+	// before the other ranges are done otherwise.
+	parts := splitRange(byteRange{0, size - 2}, connections)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(parts))
+	for _, p := range parts {
+		wg.Add(1)
+		go func(p byteRange) {
+			defer wg.Done()
+			if err := pd.fetch(ctx, p); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return true, nil, err
+	}
+
+	if err := pd.fetch(ctx, byteRange{size - 1, size - 1}); err != nil {
+		return true, nil, err
+	}
+
+	fi, err := out.Stat()
+	if err != nil {
+		return true, nil, err
+	}
+	if fi.Size() != size {
+		return true, nil, fmt.Errorf("downloaded %d bytes instead of %d", fi.Size(), size)
+	}
+	return true, checksumHeaders(resp.Header), nil
+}
+
+// splitRange splits r in at most n ranges of at least minPartSize bytes.
+func splitRange(r byteRange, n int) []byteRange {
+	length := r.end - r.start + 1
+	if max := length / minPartSize; int64(n) > max {
+		n = int(max)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	parts := make([]byteRange, 0, n)
+	partSize := length / int64(n)
+	for i := 0; i < n; i++ {
+		start := r.start + int64(i)*partSize
+		end := start + partSize - 1
+		if i == n-1 {
+			end = r.end
+		}
+		parts = append(parts, byteRange{start, end})
+	}
+	return parts
+}
+
+// parallelDownload writes ranges of url, whose content is identified by
+// validator, at the same offsets of out.
+type parallelDownload struct {
+	url       string
+	id        string
+	validator string
+	size      int64
+	out       *os.File
+	progress  *progress
+}
+
+// fetch downloads the range p. It resumes the range if the connection drops,
+// and fails if the file changed on the server.
+func (pd *parallelDownload) fetch(ctx context.Context, p byteRange) error {
+	for attempt := 1; ; attempt++ {
+		err := pd.fetchOnce(ctx, &p)
+		if _, ok := err.(*interruptedError); !ok || attempt > downloadRetries || ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// fetchOnce sends a single request for p, and moves its start past what was
+// written.
+func (pd *parallelDownload) fetchOnce(ctx context.Context, p *byteRange) error {
+	req, err := http.NewRequest(http.MethodGet, pd.url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", p.start, p.end))
+	req.Header.Set("If-Range", pd.validator)
+	req.Header.Set(downloadIDHeader, pd.id)
+
+	resp, err := httpClient.Do(req)
+	if isCertificateError(err) {
+		return err
+	} else if err != nil {
+		return &interruptedError{err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		want := fmt.Sprintf("bytes %d-%d/%d", p.start, p.end, pd.size)
+		if got := resp.Header.Get("Content-Range"); got != want {
+			return fmt.Errorf("unexpected range %q in the response, want %q", got, want)
+		}
+	case http.StatusOK:
+		return fmt.Errorf("%s changed on the server during the download", pd.url)
+	default:
+		return fmt.Errorf("cannot download %s: %s", pd.url, resp.Status)
+	}
+
+	length := p.end - p.start + 1
+	w := io.MultiWriter(io.NewOffsetWriter(pd.out, p.start), pd.progress)
+	n, err := io.Copy(w, io.LimitReader(&interruptReader{resp.Body}, length))
+	p.start += n
+	if err == nil && n < length {
+		err = &interruptedError{io.ErrUnexpectedEOF}
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// progress counts the bytes written to it, and periodically reports how many
+// were transferred, at which rate, and when the transfer should end if its
+// total size is known. Nothing is reported when stdout is not a terminal.
+type progress struct {
+	label    string
+	interval time.Duration
+	report   func(line string)
+	bar      bool
+
+	done   int64
+	total  int64
+	offset int64
+	start  time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// isTerminal reports whether stdout is a terminal.
+func isTerminal() bool {
+	return terminal.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// newProgressBar returns a progress redrawing a single line of stdout.
+func newProgressBar(label string) *progress {
+	p := newProgress(label, 200*time.Millisecond, nil)
+	if isTerminal() {
+		p.bar = true
+		p.report = func(line string) {
+			fmt.Printf("\r\033[K%s", line)
+		}
+	}
+	return p
+}
+
+// newLogProgress returns a progress logged every few seconds when stdout is a
+// terminal, for the transfers of a server.
+func newLogProgress(label string) *progress {
+	p := newProgress(label, 2*time.Second, nil)
+	if isTerminal() {
+		p.report = func(line string) {
+			logrus.Infof("%s", line)
+		}
+	}
+	return p
+}
+
+// newProgress returns a progress calling report every interval, once started.
+// A nil report disables reporting.
+func newProgress(label string, interval time.Duration, report func(string)) *progress {
+	return &progress{
+		label:    label,
+		interval: interval,
+		report:   report,
+		total:    -1,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.done, int64(len(b)))
+	return len(b), nil
+}
+
+// setTotal sets the total size of the transfer, or -1 if unknown.
+func (p *progress) setTotal(n int64) {
+	atomic.StoreInt64(&p.total, n)
+}
+
+// reset sets the number of bytes already transferred, when a transfer is
+// resumed or restarted. They are not accounted in the rate.
+func (p *progress) reset(n int64) {
+	atomic.StoreInt64(&p.done, n)
+	atomic.StoreInt64(&p.offset, n)
+}
+
+// transferred returns the number of bytes transferred so far.
+func (p *progress) transferred() int64 {
+	return atomic.LoadInt64(&p.done)
+}
+
+// Start starts reporting.
+func (p *progress) Start() {
+	p.start = time.Now()
+	if p.report == nil {
+		close(p.stopped)
+		return
+	}
+
+	go func() {
+		defer close(p.stopped)
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.report(p.line())
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting. A progress bar is drawn a last time.
+func (p *progress) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.stopped
+		if p.bar {
+			p.report(p.line())
+			fmt.Println()
+		}
+	})
+}
+
+// line describes the state of the transfer.
+func (p *progress) line() string {
+	done := atomic.LoadInt64(&p.done)
+	total := atomic.LoadInt64(&p.total)
+	elapsed := time.Since(p.start)
+
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(done-atomic.LoadInt64(&p.offset)) / elapsed.Seconds()
+	}
+
+	var b strings.Builder
+	if p.label != "" {
+		b.WriteString(p.label + "  ")
+	}
+	b.WriteString(formatBytes(done))
+	if total > 0 {
+		fmt.Fprintf(&b, " / %s  %3d%%", formatBytes(total), done*100/total)
+	}
+	fmt.Fprintf(&b, "  %s/s", formatBytes(int64(rate)))
+	if total > 0 && rate > 0 && done < total {
+		eta := time.Duration(float64(total-done) / rate * float64(time.Second))
+		fmt.Fprintf(&b, "  ETA %s", eta.Round(time.Second))
+	} else if done >= total && total > 0 {
+		fmt.Fprintf(&b, "  in %s", elapsed.Round(100*time.Millisecond))
+	}
+	return b.String()
+}
+
+// formatBytes returns n in a human readable unit.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// trackTransfers wraps h to log the progress of each download every few
+// seconds when stdout is a terminal, and a summary once it is sent.
+func trackTransfers(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		p := newLogProgress(fmt.Sprintf("%s to %s", r.URL.Path, r.RemoteAddr))
+		p.Start()
+		h.ServeHTTP(&progressWriter{ResponseWriter: w, progress: p}, r)
+		p.Stop()
+
+		// Folder listings are not worth a summary, only attachments are
+		if w.Header().Get("Content-Disposition") != "" {
+			logrus.Infof("%s", p.line())
+		}
+	})
+}
+
+// progressWriter counts the bytes of a response in a progress, whose total is
+// the Content-Length of the response.
+type progressWriter struct {
+	http.ResponseWriter
+	progress *progress
+	started  bool
+}
+
+func (pw *progressWriter) WriteHeader(status int) {
+	pw.start()
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	pw.start()
+	n, err := pw.ResponseWriter.Write(b)
+	pw.progress.Write(b[:n])
+	return n, err
+}
+
+func (pw *progressWriter) start() {
+	if pw.started {
+		return
+	}
+	pw.started = true
+	if n, err := strconv.ParseInt(pw.Header().Get("Content-Length"), 10, 64); err == nil {
+		pw.progress.setTotal(n)
+	}
+}
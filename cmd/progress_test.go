@@ -0,0 +1,23 @@
+package cmd
+
+import "testing"
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int64
+		want string
+	}{
+		{name: "bytes", n: 1023, want: "1023 B"},
+		{name: "kibibytes", n: 1024, want: "1.0 KiB"},
+		{name: "mebibytes", n: 20 * 1024 * 1024, want: "20.0 MiB"},
+		{name: "gibibytes", n: 3 * 1024 * 1024 * 1024 / 2, want: "1.5 GiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatBytes(tt.n); got != tt.want {
+				t.Errorf("formatBytes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"io"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// qrPNGSize is the width and height, in pixels, of the PNG QR codes.
+const qrPNGSize = 512
+
+// printQR renders content as a QR code in w, with Unicode half blocks so that
+// each line of text holds two rows of modules.
+func printQR(w io.Writer, content string) error {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, qrBlocks(q.Bitmap()))
+	return err
+}
+
+// qrBlocks draws bitmap with the light modules in the foreground color, which
+// reads right on the usual dark terminals.
+func qrBlocks(bitmap [][]bool) string {
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			top := !bitmap[y][x]
+			bottom := y+1 < len(bitmap) && !bitmap[y+1][x]
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// writeQRPNG writes content as a QR code in the PNG file filename.
+func writeQRPNG(content, filename string) error {
+	return qrcode.WriteFile(content, qrcode.Medium, qrPNGSize, filename)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_qrBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		bitmap [][]bool
+		want   string
+	}{
+		{name: "even rows", bitmap: [][]bool{{false, true, false, true}, {false, false, true, true}}, want: "█▄▀ \n"},
+		{name: "odd rows", bitmap: [][]bool{{false, true}, {true, true}, {false, true}}, want: "▀ \n▀ \n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := qrBlocks(tt.bitmap); got != tt.want {
+				t.Errorf("qrBlocks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_printQR(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printQR(&buf, "http://192.168.1.36:8080/notes.txt?token=0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatalf("printQR() error = %v", err)
+	}
+
+	// The code is square, with two rows of modules per line
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	width := utf8.RuneCountInString(lines[0])
+	for _, l := range lines {
+		if utf8.RuneCountInString(l) != width {
+			t.Fatalf("printQR() drew lines of %d and %d modules", utf8.RuneCountInString(l), width)
+		}
+	}
+	if len(lines) != (width+1)/2 {
+		t.Errorf("printQR() drew %d lines for %d modules", len(lines), width)
+	}
+}
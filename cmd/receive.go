@@ -0,0 +1,473 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// receiveCmd represents the receive command
+var receiveCmd = &cobra.Command{
+	Use:   "receive",
+	Short: "Receive files uploaded by others",
+	Long: `receive starts a HTTP server where others can upload files to you, from a web
+page or with any HTTP client. For example:
+
+This will write the files uploaded on http://0.0.0.0:8080/ in the current folder:
+  shaloc receive
+
+This will write them in ~/inbox, refusing files bigger than 1 GiB, and stop
+after 3 files:
+  shaloc receive -d ~/inbox --max-size 1G -m 3
+
+Files can be uploaded from a browser, or with:
+  curl -T file.txt http://192.168.1.36:8080/
+
+This will expect files encrypted with a passphrase, and decrypt them on the fly:
+  shaloc receive --aes
+`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		ip, _ := cmd.Flags().GetString("ip")
+		port, _ := cmd.Flags().GetString("port")
+		dir, _ := cmd.Flags().GetString("dir")
+		maxSize, _ := cmd.Flags().GetString("max-size")
+		randomize, _ := cmd.Flags().GetInt("random")
+		maxUploads, _ := cmd.Flags().GetInt("max")
+		useAES, _ := cmd.Flags().GetBool("aes")
+		identityFiles, _ := cmd.Flags().GetStringArray("identity")
+		kdfTime, _ := cmd.Flags().GetUint32("kdf-time")
+		kdfMemory, _ := cmd.Flags().GetUint32("kdf-memory")
+		kdfThreads, _ := cmd.Flags().GetUint8("kdf-threads")
+
+		if maxUploads == 0 {
+			fmt.Println("The maximum number of uploads (-m) must be positive !")
+			os.Exit(1)
+		}
+
+		limit, err := parseSize(maxSize)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		isFol, err := isFolder(dir)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		if !isFol {
+			logrus.Fatalf("%s is not a folder", dir)
+		}
+
+		sec, err := askForSecrets(useAES, identityFiles)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		// The uploaders choose the cost of the key derivation: only run the
+		// ones the receiver would have chosen
+		if sec != nil {
+			sec.limit = &kdfLimit{time: kdfTime, memory: kdfMemory, threads: kdfThreads}
+		}
+
+		// If the flag -r is provided, randomize the URI
+		base := "/"
+		if randomize > 0 {
+			rand.Seed(time.Now().UnixNano())
+			base = "/" + randID(randomize) + "/"
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		rcv := &receiver{dir: dir, base: base, maxSize: limit, remaining: maxUploads, sec: sec, done: cancel}
+		mux := http.NewServeMux()
+		mux.Handle(base, rcv)
+		if base != "/" {
+			mux.Handle(strings.TrimSuffix(base, "/"), http.RedirectHandler(base, http.StatusMovedPermanently))
+		}
+
+		srv := &http.Server{
+			Addr:    net.JoinHostPort(ip, port),
+			Handler: mux,
+		}
+
+		fmt.Printf("Receiving files in %s on http://%s%s\n", dir, net.JoinHostPort(ip, port), base)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.Fatalf("%s", err)
+			}
+		}()
+
+		<-ctx.Done()
+		shutdownCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+		defer stop()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("%s", err)
+		}
+		logrus.Infof("Max number of uploads reached, shutting down the server.")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(receiveCmd)
+	receiveCmd.Flags().StringP("ip", "i", "0.0.0.0", "IP address to serve on.")
+	receiveCmd.Flags().StringP("port", "p", "8080", "Port to serve on.")
+	receiveCmd.Flags().StringP("dir", "d", ".", "Folder to write the received files in.")
+	receiveCmd.Flags().String("max-size", "", "Maximum size of a received file, such as 500M or 2G. Unlimited if empty.")
+	receiveCmd.Flags().IntP("random", "r", 0, "Randomize the URI. The integer provided is the random string lentgh.")
+	receiveCmd.Flags().IntP("max", "m", -1, "Maximum number of files to receive.")
+	receiveCmd.Flags().Bool("aes", false, "Decrypt the received files with a passphrase.")
+	receiveCmd.Flags().Uint32("kdf-time", defaultKDFTime, "Highest number of passes of argon2id accepted from the received files.")
+	receiveCmd.Flags().Uint32("kdf-memory", defaultKDFMemory, "Highest memory used by the key derivation accepted from the received files, in MiB.")
+	receiveCmd.Flags().Uint8("kdf-threads", defaultKDFThreads, "Highest number of threads of the key derivation accepted from the received files.")
+	receiveCmd.Flags().StringArray("identity", nil, "Identity file created by 'shaloc keygen' to decrypt the received files with. Can be repeated.")
+}
+
+var errTooLarge = errors.New("file too large")
+
+var uploadTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>shaloc</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+</style>
+</head>
+<body>
+<h1>Send files</h1>
+<form method="post" enctype="multipart/form-data" action="{{.}}">
+<p><input type="file" name="file" multiple required></p>
+<p><input type="submit" value="Send"></p>
+</form>
+</body>
+</html>
+`))
+
+// receiver writes the files uploaded under base in dir. Files are either sent
+// with a multipart form to base, or as the body of a PUT or POST request to
+// base followed by their name.
+type receiver struct {
+	dir     string
+	base    string
+	maxSize int64
+	sec     *secrets
+
+	// remaining is the number of files that can still be received, or -1
+	// if unlimited, and pending the number of uploads in progress. done is
+	// called once the last file is received.
+	mu        sync.Mutex
+	remaining int
+	pending   int
+	done      func()
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, rc.base)
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uploadTemplate.Execute(w, rc.base); err != nil {
+			logrus.Errorf("%s", err)
+		}
+	case name == "" && r.Method == http.MethodPost:
+		rc.receiveForm(w, r)
+	case name != "" && (r.Method == http.MethodPut || r.Method == http.MethodPost):
+		rc.receiveBody(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// receiveForm writes the files of a multipart form.
+func (rc *receiver) receiveForm(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart form", http.StatusBadRequest)
+		return
+	}
+
+	var received []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "malformed multipart form", http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		name, status, err := rc.receive(part.FileName(), part, -1, nil, r.RemoteAddr)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		received = append(received, name)
+	}
+
+	if len(received) == 0 {
+		http.Error(w, "no file in the form", http.StatusBadRequest)
+		return
+	}
+	for _, name := range received {
+		fmt.Fprintf(w, "Received %s\n", name)
+	}
+}
+
+// receiveBody writes the body of the request in the file name.
+func (rc *receiver) receiveBody(w http.ResponseWriter, r *http.Request, name string) {
+	if rc.maxSize > 0 && r.ContentLength > rc.maxSize {
+		http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Checksums can be sent in headers, or in trailers when they are computed
+	// while uploading.
+	checksums := func() http.Header {
+		return checksumHeaders(r.Header, r.Trailer)
+	}
+	saved, status, err := rc.receive(name, r.Body, r.ContentLength, checksums, r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	fmt.Fprintf(w, "Received %s\n", saved)
+}
+
+// receive writes what is read from body, of the given size or -1, in dir
+// under the sanitized version of name, and returns the name of the file. The
+// checksums returned by checksums once the body is read, if not nil, are
+// verified. On error, nothing is left behind and the HTTP status to answer is
+// returned.
+func (rc *receiver) receive(name string, body io.Reader, size int64, checksums func() http.Header, remote string) (string, int, error) {
+	name = sanitizeFilename(name)
+	if name == "" {
+		return "", http.StatusBadRequest, errors.New("invalid file name")
+	}
+
+	if !rc.reserve() {
+		return "", http.StatusGone, errors.New("no more files are accepted")
+	}
+	saved := false
+	defer func() { rc.release(saved) }()
+
+	f, name, err := createUnique(rc.dir, name)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		return "", http.StatusInternalServerError, errors.New("cannot create file")
+	}
+	path := f.Name()
+
+	p := newLogProgress(fmt.Sprintf("%s from %s", name, remote))
+	p.setTotal(size)
+	p.Start()
+	status, err := rc.write(f, io.TeeReader(body, p), checksums)
+	p.Stop()
+
+	if cerr := f.Close(); err == nil && cerr != nil {
+		status, err = http.StatusInternalServerError, cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		logrus.Warnf("Rejected %s from %s: %s", name, remote, err)
+		return "", status, err
+	}
+
+	saved = true
+	logrus.Infof("Received %s (%s) from %s", name, formatBytes(p.transferred()), remote)
+	return name, http.StatusOK, nil
+}
+
+// write copies body in f, decrypting it if needed, and verifies the
+// checksums returned by checksums.
+func (rc *receiver) write(f *os.File, body io.Reader, checksums func() http.Header) (int, error) {
+	if rc.maxSize > 0 {
+		body = &sizeLimitReader{r: body, remaining: rc.maxSize}
+	}
+	hw := newHashWriter(newHashes(true))
+	body = io.TeeReader(body, hw)
+
+	if rc.sec != nil {
+		// Only the streaming format is accepted, the older ones would be
+		// loaded in memory.
+		br := bufio.NewReader(body)
+		header, err := br.Peek(len(containerMagic) + 1)
+		if err != nil || string(header[:len(containerMagic)]) != containerMagic || header[len(containerMagic)] != containerV2 {
+			return http.StatusBadRequest, errors.New("expected a file encrypted by shaloc")
+		}
+		if body, err = newDecryptReader(br, rc.sec); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if _, err := io.Copy(f, body); err != nil {
+		return uploadStatus(err), err
+	}
+	if checksums == nil {
+		return http.StatusOK, nil
+	}
+	if _, err := verifyChecksums(checksums(), hw); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+// uploadStatus returns the HTTP status answering an upload that failed with
+// err.
+func uploadStatus(err error) int {
+	switch {
+	case err == errTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case err == errDecrypt:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// reserve reserves the reception of a file, and reports whether one more file
+// can be received.
+func (rc *receiver) reserve() bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.remaining >= 0 && rc.pending >= rc.remaining {
+		return false
+	}
+	rc.pending++
+	return true
+}
+
+// release ends a reservation. If the file was saved, it is counted, and done
+// is called once no more files can be received.
+func (rc *receiver) release(saved bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.pending--
+	if !saved || rc.remaining < 0 {
+		return
+	}
+	rc.remaining--
+	logrus.Infof("Uploads remaining: %d", rc.remaining)
+	if rc.remaining == 0 {
+		rc.done()
+	}
+}
+
+// sanitizeFilename returns a file name that is safe to create in a folder
+// from name, which comes from the network: only its last element is kept, and
+// control characters and leading dots are removed. It returns an empty string
+// if nothing usable is left.
+func sanitizeFilename(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == ':' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+
+	if len(name) > 255 {
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		// Cut the name on a character boundary
+		n := 255 - len(ext)
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n] + ext
+	}
+	return name
+}
+
+// createUnique creates the file name in dir, or name with a numeric suffix if
+// it already exists. Existing files are never overwritten.
+func createUnique(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, candidate, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// sizeLimitReader fails with errTooLarge once more than remaining bytes are
+// read.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (sl *sizeLimitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > sl.remaining+1 {
+		p = p[:sl.remaining+1]
+	}
+	n, err := sl.r.Read(p)
+	sl.remaining -= int64(n)
+	if sl.remaining < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// sizeUnits are the units accepted by parseSize.
+var sizeUnits = map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+
+// parseSize parses a size in bytes, optionally followed by a K, M, G or T
+// unit in powers of 1024, such as 500M or 2GiB. An empty size is 0.
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	if s == "" {
+		return 0, nil
+	}
+
+	mult := int64(1)
+	if m, ok := sizeUnits[s[len(s)-1]]; ok {
+		mult = m
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_sanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "file.txt", want: "file.txt"},
+		{name: "path", in: "../../etc/passwd", want: "passwd"},
+		{name: "windows path", in: `C:\Users\me\file.txt`, want: "file.txt"},
+		{name: "hidden", in: ".bashrc", want: "bashrc"},
+		{name: "dot dot", in: "..", want: ""},
+		{name: "control characters", in: "a\x00b\nc.txt", want: "abc.txt"},
+		{name: "trailing slash", in: "dir/", want: ""},
+		{name: "too long", in: strings.Repeat("a", 300) + ".txt", want: strings.Repeat("a", 251) + ".txt"},
+		{name: "too long multi-byte", in: strings.Repeat("é", 150) + ".txt", want: strings.Repeat("é", 125) + ".txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.in); got != tt.want {
+				t.Errorf("sanitizeFilename() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: 0},
+		{name: "bytes", in: "123", want: 123},
+		{name: "mebibytes", in: "500M", want: 500 << 20},
+		{name: "gibibytes", in: "2GiB", want: 2 << 30},
+		{name: "lower case", in: "1k", want: 1024},
+		{name: "unknown unit", in: "1X", wantErr: true},
+		{name: "negative", in: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_receiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	done := false
+	rc := &receiver{dir: dir, base: "/", maxSize: 10, remaining: 3, done: func() { done = true }}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	put := func(name, body string) int {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/"+name, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := put("a.txt", "plop"); got != http.StatusOK {
+		t.Errorf("PUT a.txt = %d", got)
+	}
+	if got := put("a.txt", "plip"); got != http.StatusOK {
+		t.Errorf("PUT a.txt again = %d", got)
+	}
+	if got := put("big.txt", "more than ten bytes"); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT big.txt = %d", got)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("file", "../form.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("form"))
+	mw.Close()
+	resp, err := http.Post(srv.URL+"/", mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("POST form = %d", resp.StatusCode)
+	}
+
+	if got := put("late.txt", "late"); got != http.StatusGone {
+		t.Errorf("PUT late.txt = %d", got)
+	}
+	if !done {
+		t.Errorf("receiver did not stop after 3 files")
+	}
+
+	for name, want := range map[string]string{"a.txt": "plop", "a-2.txt": "plip", "form.txt": "form"} {
+		got, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"big.txt", "late.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("%s was written", name)
+		}
+	}
+}
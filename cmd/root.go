@@ -31,7 +31,7 @@ shaloc is a tool designed to share files on a local network over HTTP in command
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	// Implement graceful shutdown
-	var gracefulStop = make(chan os.Signal)
+	var gracefulStop = make(chan os.Signal, 1)
 
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
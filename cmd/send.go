@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// sendCmd represents the send command
+var sendCmd = &cobra.Command{
+	Use:   "send",
+	Short: "Send a file or a folder to a receive server",
+	Long: `send uploads a file or a folder to a server started with 'shaloc receive', or
+to any server accepting PUT requests. It is useful when you cannot accept
+incoming connections, but the recipient can. For example:
+
+This will upload file.txt to http://192.168.1.36:8080/file.txt:
+  shaloc send -f file.txt -u http://192.168.1.36:8080/
+
+This will upload the folder as a zip archive built on the fly:
+  shaloc send -F /home/user/sup3r-f0ld3r -u http://192.168.1.36:8080/
+
+This will encrypt file.txt with a passphrase, for 'shaloc receive --aes':
+  shaloc send -f file.txt -u http://192.168.1.36:8080/ --aes
+`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		target, _ := cmd.Flags().GetString("url")
+		file, _ := cmd.Flags().GetString("file")
+		folder, _ := cmd.Flags().GetString("folder")
+		format, _ := cmd.Flags().GetString("format")
+
+		if target == "" {
+			fmt.Println("You must provide a URL with the flag -u !")
+			os.Exit(1)
+		} else if file == "" && folder == "" {
+			fmt.Println("You must provide a file to send (-f) or a folder (-F) !")
+			os.Exit(1)
+		} else if file != "" && folder != "" {
+			fmt.Println("You cannot provide a file and a folder !")
+			os.Exit(1)
+		} else if _, ok := archiveExtensions[format]; !ok {
+			fmt.Printf("Unknown archive format %s, use one of %s !\n", format, strings.Join(archiveFormats(), ", "))
+			os.Exit(1)
+		}
+
+		s := &sender{file: file, folder: folder, format: format}
+		if _, err := newShareItem(s.source(), "", folder != "", -1); err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		enc, err := encryptorFromFlags(cmd)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+		s.enc = enc
+
+		// A URL ending with a slash is a folder, the name is appended to it
+		if strings.HasSuffix(target, "/") {
+			target += url.PathEscape(s.name())
+		}
+
+		answer, err := s.send(target)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		fmt.Printf("Sent %s to %s\n", s.source(), target)
+		if answer != "" {
+			fmt.Println(answer)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sendCmd)
+	sendCmd.Flags().StringP("url", "u", "", "URL to send the file to. If it ends with a slash, the name of the file is appended.")
+	sendCmd.Flags().StringP("file", "f", "", "File to send.")
+	sendCmd.Flags().StringP("folder", "F", "", "Folder to send. It will be archived on the fly.")
+	sendCmd.Flags().String("format", "zip", "Archive format of sent folders: "+strings.Join(archiveFormats(), ", ")+".")
+	addEncryptionFlags(sendCmd)
+}
+
+// sender uploads a file, or a folder archived on the fly, encrypted with enc
+// if it is not nil.
+type sender struct {
+	file   string
+	folder string
+	format string
+	enc    *encryptor
+}
+
+// source returns the path of what is sent.
+func (s *sender) source() string {
+	if s.folder != "" {
+		return s.folder
+	}
+	return s.file
+}
+
+// name returns the name of the uploaded file.
+func (s *sender) name() string {
+	if s.folder != "" {
+		return filepath.Base(s.folder) + archiveExtensions[s.format]
+	}
+	return filepath.Base(s.file)
+}
+
+// send uploads the content to target, and returns the answer of the server.
+// The upload is retried from the beginning if the connection drops.
+func (s *sender) send(target string) (string, error) {
+	for attempt := 1; ; attempt++ {
+		answer, err := s.sendOnce(target)
+		if _, ok := err.(*interruptedError); !ok || attempt > downloadRetries {
+			return answer, err
+		}
+
+		delay := time.Duration(attempt) * time.Second
+		logrus.Warnf("%s, retrying in %s", err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// sendOnce uploads the content to target with a PUT request. Its checksums
+// are computed while it is sent, and sent as trailers.
+func (s *sender) sendOnce(target string) (string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptTo(pw, s.enc, s.write))
+	}()
+	defer pr.Close()
+
+	size, err := s.size()
+	if err != nil {
+		return "", err
+	}
+	progress := newProgressBar(s.name())
+	progress.setTotal(size)
+
+	hw := newHashWriter(newHashes(true))
+	body := &trailerReader{r: io.TeeReader(pr, io.MultiWriter(hw, progress)), hw: hw}
+	req, err := http.NewRequest(http.MethodPut, target, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = -1
+	req.Trailer = http.Header{}
+	for h := range hw.hashes {
+		req.Trailer[h] = nil
+	}
+	body.trailer = req.Trailer
+
+	progress.Start()
+	resp, err := http.DefaultClient.Do(req)
+	progress.Stop()
+	if err != nil {
+		if _, ok := err.(*url.Error); ok && body.err == nil {
+			return "", &interruptedError{err}
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	answer, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch {
+	case resp.StatusCode >= 500:
+		return "", &interruptedError{fmt.Errorf("server answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))}
+	case resp.StatusCode >= 300:
+		return "", fmt.Errorf("server answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return strings.TrimSpace(string(answer)), nil
+}
+
+// write writes the plain content to w.
+func (s *sender) write(w io.Writer) error {
+	if s.folder != "" {
+		return writeArchive(w, s.folder, s.format)
+	}
+
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// size returns the size of what is sent, or -1 if it is not known in
+// advance.
+func (s *sender) size() (int64, error) {
+	if s.folder != "" {
+		return -1, nil
+	}
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return 0, err
+	}
+	if s.enc != nil {
+		return s.enc.encryptedSize(fi.Size()), nil
+	}
+	return fi.Size(), nil
+}
+
+// trailerReader sets the checksums computed by hw in trailer once r is read
+// entirely, so that they are sent after the body. It records the errors of r,
+// which are local errors rather than network ones.
+type trailerReader struct {
+	r       io.Reader
+	hw      *hashWriter
+	trailer http.Header
+	err     error
+}
+
+func (tr *trailerReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if err == io.EOF {
+		for h, sum := range tr.hw.sums() {
+			tr.trailer.Set(h, sum)
+		}
+	} else if err != nil {
+		tr.err = err
+	}
+	return n, err
+}
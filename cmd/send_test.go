@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_sender_send(t *testing.T) {
+	src, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dest, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	content := []byte("hello, receiver")
+	if err := ioutil.WriteFile(filepath.Join(src, "file.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	folder := filepath.Join(src, "folder")
+	if err := os.Mkdir(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(folder, "inner.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &receiver{dir: dest, base: "/", remaining: -1, done: func() {}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	t.Run("file", func(t *testing.T) {
+		s := &sender{file: filepath.Join(src, "file.txt")}
+		if _, err := s.send(srv.URL + "/" + s.name()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(dest, "file.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(content) {
+			t.Errorf("received %q, want %q", got, content)
+		}
+	})
+
+	t.Run("folder", func(t *testing.T) {
+		s := &sender{folder: folder, format: "zip"}
+		if _, err := s.send(srv.URL + "/" + s.name()); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.OpenReader(filepath.Join(dest, "folder.zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		if len(zr.File) == 0 {
+			t.Errorf("received an empty archive")
+		}
+	})
+
+	t.Run("trailer", func(t *testing.T) {
+		var got string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body)
+			got = r.Trailer.Get(sha256Header)
+		}))
+		defer srv.Close()
+
+		s := &sender{file: filepath.Join(src, "file.txt")}
+		if _, err := s.send(srv.URL + "/file.txt"); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(content)
+		if want := hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("trailer %s = %q, want %q", sha256Header, got, want)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			http.Error(w, "no more uploads", http.StatusGone)
+		}))
+		defer srv.Close()
+
+		s := &sender{file: filepath.Join(src, "file.txt")}
+		if _, err := s.send(srv.URL + "/file.txt"); err == nil {
+			t.Fatal("send() succeeded, want an error")
+		}
+		if attempts != 1 {
+			t.Errorf("sent %d times, want 1", attempts)
+		}
+	})
+}
@@ -0,0 +1,540 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// shareItem is a file or a folder served by a share session.
+type shareItem struct {
+	path   string
+	uri    string
+	folder bool
+	size   int64
+
+	// unlisted hides the item from the index, when its URI is a secret.
+	unlisted bool
+	// code is set when the URI is a word code.
+	code bool
+
+	// remaining is the number of downloads left, or -1 if unlimited.
+	remaining int
+	// reserved is the number of downloads in progress, which hold one of the
+	// remaining slots.
+	reserved int
+	// windows are the downloads in progress by their id.
+	windows map[string]*downloadWindow
+}
+
+// downloadWindow is a download holding a slot of an item, made of one or more
+// requests.
+type downloadWindow struct {
+	// active is the number of requests in progress.
+	active int
+	// sent is set once a request was answered entirely, and aborted once one
+	// was aborted by the client.
+	sent    bool
+	aborted bool
+	// counted is set once the download was counted.
+	counted bool
+	timer   *time.Timer
+}
+
+const (
+	// downloadIDHeader carries the id a client gives to all the requests of a
+	// download, so that they take a single slot.
+	downloadIDHeader = "X-Shaloc-Download"
+	// downloadWindowTimeout is how long a download id keeps its slot after
+	// its last request.
+	downloadWindowTimeout = time.Minute
+)
+
+// indexEntry describes a shared item on the index page.
+type indexEntry struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Dir       bool   `json:"dir"`
+	Size      int64  `json:"size"`
+	Remaining int    `json:"remaining"`
+}
+
+// session serves several files and folders on the same server, each at its
+// own URI, and lists them on an index page at /. Every item has its own
+// download limit, and done is called once they are all exhausted. A download
+// reserves a slot when it starts, and counts once any of its requests was
+// answered entirely: aborted downloads release their slot, unless countAborted
+// is set.
+type session struct {
+	mu           sync.Mutex
+	items        []*shareItem
+	enc          *encryptor
+	pake         *pakeServer
+	sums         *checksumCache
+	format       string
+	browse       bool
+	countAborted bool
+	done         func()
+
+	// routes maps the paths of the items and of their checksums to their
+	// handlers, and prefixes serves the browsed folders. Item names may
+	// contain anything, so they are not registered as mux patterns.
+	routes   map[string]http.Handler
+	prefixes []prefixRoute
+}
+
+// prefixRoute serves the paths starting with prefix.
+type prefixRoute struct {
+	prefix string
+	h      http.Handler
+}
+
+// downloadFunc serves a download of content whose size is given, or -1 if
+// ranges of it cannot be requested, with send. send reports whether the end of
+// the content was sent.
+type downloadFunc func(w http.ResponseWriter, r *http.Request, size int64, send func() (bool, error))
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>shaloc</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+td { padding: 0.2em 1em 0.2em 0; }
+.size, .remaining { color: #666; }
+</style>
+</head>
+<body>
+<h1>Shared items</h1>
+<table>
+{{- range .}}
+<tr><td><a href="{{.Path}}">{{.Name}}</a></td><td class="size">{{if .Dir}}-{{else}}{{.Size}}{{end}}</td><td class="remaining">{{if ge .Remaining 0}}{{.Remaining}} download(s) left{{end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// newShareItem checks that name is a file, or a folder if folder is set, and
+// returns the item serving it at uri.
+func newShareItem(name, uri string, folder bool, max int) (*shareItem, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if folder && !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a folder", name)
+	}
+	if !folder && fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a file", name)
+	}
+	return &shareItem{path: name, uri: uri, folder: folder, size: fi.Size(), remaining: max}, nil
+}
+
+// urlPath returns the escaped path of the URL of it, with a trailing slash
+// for the folders that are browsed.
+func (it *shareItem) urlPath(browse bool) string {
+	p := (&url.URL{Path: "/" + it.uri}).EscapedPath()
+	if it.folder && browse {
+		p += "/"
+	}
+	return p
+}
+
+// itemURI returns the URI to serve the file or folder name at, and marks it
+// taken: its base name followed by ext, a random ID of randomize characters,
+// or a word code if code is set.
+func itemURI(name, ext string, randomize int, code bool, taken map[string]bool) (string, error) {
+	uri := filepath.Base(name) + ext
+	if randomize > 0 {
+		uri = randID(randomize)
+	}
+	if code {
+		uri = ""
+		for uri == "" || taken[uri] {
+			var err error
+			if uri, err = newWordCode(codeWordCount); err != nil {
+				return "", err
+			}
+		}
+	}
+	return uniqueURI(uri, taken), nil
+}
+
+// uniqueURI returns uri, or uri with a numeric suffix before its extension if
+// it is already taken.
+func uniqueURI(uri string, taken map[string]bool) string {
+	ext := path.Ext(uri)
+	if strings.HasSuffix(uri, ".tar"+ext) {
+		ext = ".tar" + ext
+	}
+	base := strings.TrimSuffix(uri, ext)
+
+	candidate := uri
+	for i := 2; taken[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	taken[candidate] = true
+	return candidate
+}
+
+// available reports whether it can still be downloaded.
+func (s *session) available(it *shareItem) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return it.remaining != 0
+}
+
+// join adds a request to the download id of it, which takes a slot of it if it
+// is a new one, and reports whether one was left. Requests without an id are
+// downloads of their own.
+func (s *session) join(it *shareItem, id string) (*downloadWindow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if win, ok := it.windows[id]; ok && id != "" {
+		win.active++
+		if win.timer != nil {
+			win.timer.Stop()
+		}
+		return win, true
+	}
+	if it.remaining >= 0 && it.remaining-it.reserved <= 0 {
+		return nil, false
+	}
+	if it.remaining >= 0 {
+		it.reserved++
+	}
+
+	// Unlimited items have nothing to settle later
+	win := &downloadWindow{active: 1}
+	if id != "" && it.remaining >= 0 {
+		if it.windows == nil {
+			it.windows = map[string]*downloadWindow{}
+		}
+		it.windows[id] = win
+	}
+	return win, true
+}
+
+// leave ends a request of the download win of it, which sent its whole
+// response if sent, including the end of the content if end is set, or was
+// aborted by the client if aborted is set. The download is counted as soon as
+// its end is sent, and otherwise settled once its id has had no request for
+// downloadWindowTimeout, or right away without an id.
+func (s *session) leave(it *shareItem, id string, win *downloadWindow, sent, end, aborted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	win.active--
+	win.sent = win.sent || sent
+	win.aborted = win.aborted || aborted
+	if end && !win.counted {
+		win.counted = true
+		s.downloaded(it)
+	}
+
+	switch {
+	case it.windows[id] != win:
+		s.settle(it, win)
+	case win.active == 0:
+		win.timer = time.AfterFunc(downloadWindowTimeout, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if win.active == 0 && it.windows[id] == win {
+				delete(it.windows, id)
+				s.settle(it, win)
+			}
+		})
+	}
+}
+
+// settle counts the download win of it if some content was sent, or if it was
+// aborted and countAborted is set, and releases its slot otherwise. s.mu must
+// be held.
+func (s *session) settle(it *shareItem, win *downloadWindow) {
+	switch {
+	case win.counted:
+	case win.sent:
+		s.downloaded(it)
+	case win.aborted && s.countAborted:
+		logrus.Warnf("Download of %s aborted, counting it", it.uri)
+		s.downloaded(it)
+	default:
+		if win.aborted {
+			logrus.Infof("Download of %s aborted, releasing its slot", it.uri)
+		}
+		if it.remaining >= 0 && it.reserved > 0 {
+			it.reserved--
+		}
+	}
+}
+
+// downloaded counts a download of it, whose slot was reserved, and calls done
+// once no item can be downloaded anymore. s.mu must be held.
+func (s *session) downloaded(it *shareItem) {
+	if it.remaining < 0 {
+		return
+	}
+	if it.reserved > 0 {
+		it.reserved--
+	}
+	if it.remaining > 0 {
+		it.remaining--
+	}
+	logrus.Infof("Downloads remaining for %s: %d", it.uri, it.remaining)
+
+	for _, i := range s.items {
+		if i.remaining != 0 {
+			return
+		}
+	}
+	s.done()
+}
+
+// register adds the handler of the items, of their checksums and of the index
+// to mux.
+func (s *session) register(mux *http.ServeMux) error {
+	s.routes = map[string]http.Handler{}
+	for _, it := range s.items {
+		it := it
+		if it.folder && s.browse {
+			prefix := "/" + it.uri + "/"
+			h, err := newBrowseHandler(it.path, prefix, s.enc, s.sums, func(w http.ResponseWriter, r *http.Request, size int64, send func() (bool, error)) {
+				s.download(w, r, it, size, send)
+			})
+			if err != nil {
+				return err
+			}
+			limited := s.limit(it, trackTransfers(h))
+			s.prefixes = append(s.prefixes, prefixRoute{prefix: prefix, h: limited})
+			s.routes["/"+it.uri] = limited
+			continue
+		}
+		s.routes["/"+it.uri] = s.limit(it, trackTransfers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.serveItem(w, r, it)
+		})))
+
+		// Compute the checksums of plain files right away, rather than on
+		// the first request.
+		if !it.folder && s.enc == nil {
+			go func() {
+				if fi, err := os.Stat(it.path); err == nil {
+					s.sums.get(it.path, fi)
+				}
+			}()
+		}
+	}
+	if s.pake != nil {
+		if _, taken := s.routes[pakePath]; taken {
+			return fmt.Errorf("nothing can be shared at %s with --pake, the handshakes run there", pakePath)
+		}
+		s.routes[pakePath] = s.pake
+	}
+	s.routeChecksums()
+	mux.HandleFunc("/", s.route)
+	return nil
+}
+
+// route serves r with the handler of the item at its path, or of the browsed
+// folder it is in, or with the index.
+func (s *session) route(w http.ResponseWriter, r *http.Request) {
+	if h, ok := s.routes[r.URL.Path]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(r.URL.Path, p.prefix) {
+			p.h.ServeHTTP(w, r)
+			return
+		}
+	}
+	s.serveIndex(w, r)
+}
+
+// routeChecksums serves the checksums of the plain shared files at their URI
+// followed by .sha256, or .blake3. The routes already taken win over them.
+func (s *session) routeChecksums() {
+	if s.enc != nil {
+		return
+	}
+
+	for _, it := range s.items {
+		it := it
+		if it.folder {
+			continue
+		}
+		for suffix, header := range checksumSuffixes {
+			header := header
+			p := "/" + it.uri + suffix
+			if _, taken := s.routes[p]; taken || (header == blake3Header && !s.sums.blake3) {
+				continue
+			}
+			s.routes[p] = s.limit(it, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				s.sums.serveChecksum(w, r, it.path, header)
+			}))
+		}
+	}
+}
+
+// limit wraps h so that it is gone once it is exhausted.
+func (s *session) limit(it *shareItem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.available(it) {
+			http.Error(w, "no download left", http.StatusGone)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// download serves a download of it with send, as a downloadFunc. Every GET
+// request takes a slot of it, and is gone if none is left, unless it belongs
+// to a download that already holds one: the requests carrying the same id in
+// downloadIDHeader, like the parts of a parallel download, share a single
+// slot. A download is counted once it sent something entirely, even a single
+// range, and only aborted ones give their slot back.
+func (s *session) download(w http.ResponseWriter, r *http.Request, it *shareItem, size int64, send func() (bool, error)) {
+	id := r.Header.Get(downloadIDHeader)
+	var win *downloadWindow
+	if r.Method != http.MethodHead {
+		var ok bool
+		if win, ok = s.join(it, id); !ok {
+			http.Error(w, "no download left", http.StatusGone)
+			return
+		}
+	}
+
+	sent, err := send()
+	if err != nil {
+		logrus.Errorf("%s", err)
+	}
+	if win != nil {
+		s.leave(it, id, win, sent, sent && reachesEnd(r.Header.Get("Range"), size), r.Context().Err() != nil)
+	}
+
+	// A response that failed must not end like a complete one, which the
+	// client would take for the whole content
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// reachesEnd reports whether the Range header rng may request the end of
+// content whose size is given, or -1 if ranges are not honoured.
+func reachesEnd(rng string, size int64) bool {
+	specs, ok := strings.CutPrefix(rng, "bytes=")
+	if rng == "" || size < 0 || !ok {
+		return true
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok || start == "" || end == "" {
+			// Suffix and open ranges end with the content
+			return true
+		}
+		if n, err := strconv.ParseInt(end, 10, 64); err != nil || n >= size-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// serveItem sends a shared file, or a shared folder as an archive built on
+// the fly.
+func (s *session) serveItem(w http.ResponseWriter, r *http.Request, it *shareItem) {
+	if it.folder {
+		archive := filepath.Base(it.path) + archiveExtensions[s.format]
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archive}))
+		w.Header().Set("Accept-Ranges", "none")
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		s.download(w, r, it, -1, func() (bool, error) {
+			err := serveStream(w, s.enc, s.sums, func(w io.Writer) error {
+				return writeArchive(w, it.path, s.format)
+			})
+			return err == nil, err
+		})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(it.path)}))
+	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+
+	f, err := os.Open(it.path)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		http.Error(w, "cannot open file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Encrypted files are always sent entirely
+	size := it.size
+	if s.enc != nil {
+		size = -1
+	}
+	s.download(w, r, it, size, func() (bool, error) {
+		return serveFile(w, r, f, s.enc, s.sums)
+	})
+}
+
+// serveIndex lists the items that can still be downloaded, in HTML or in JSON
+// for scripts.
+func (s *session) serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	entries := []indexEntry{}
+	s.mu.Lock()
+	for _, it := range s.items {
+		if it.remaining == 0 || it.unlisted {
+			continue
+		}
+		e := indexEntry{
+			Name:      it.uri,
+			Path:      (&url.URL{Path: "/" + it.uri}).EscapedPath(),
+			Dir:       it.folder,
+			Remaining: it.remaining,
+		}
+		if it.folder && s.browse {
+			e.Path += "/"
+		}
+		if !it.folder {
+			e.Size = it.size
+		}
+		entries = append(entries, e)
+	}
+	s.mu.Unlock()
+
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			logrus.Errorf("%s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, entries); err != nil {
+		logrus.Errorf("%s", err)
+	}
+}
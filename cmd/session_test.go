@@ -0,0 +1,351 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_shareItem_urlPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		it     *shareItem
+		browse bool
+		want   string
+	}{
+		{name: "file", it: &shareItem{uri: "notes.txt"}, want: "/notes.txt"},
+		{name: "special characters", it: &shareItem{uri: "a b#1?%.txt"}, want: "/a%20b%231%3F%25.txt"},
+		{name: "archive", it: &shareItem{uri: "my pics.zip", folder: true}, want: "/my%20pics.zip"},
+		{name: "browsed folder", it: &shareItem{uri: "my pics", folder: true}, browse: true, want: "/my%20pics/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.it.urlPath(tt.browse); got != tt.want {
+				t.Errorf("urlPath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uniqueURI(t *testing.T) {
+	taken := map[string]bool{}
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{name: "first", uri: "file.txt", want: "file.txt"},
+		{name: "second", uri: "file.txt", want: "file-2.txt"},
+		{name: "third", uri: "file.txt", want: "file-3.txt"},
+		{name: "tar", uri: "folder.tar.gz", want: "folder.tar.gz"},
+		{name: "second tar", uri: "folder.tar.gz", want: "folder-2.tar.gz"},
+		{name: "no extension", uri: "folder", want: "folder"},
+		{name: "second no extension", uri: "folder", want: "folder-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uniqueURI(tt.uri, taken); got != tt.want {
+				t.Errorf("uniqueURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_itemURI(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		ext       string
+		randomize int
+		code      bool
+		want      string
+	}{
+		{name: "file", path: "/tmp/notes.txt", want: "notes.txt"},
+		{name: "folder", path: "/tmp/pics", ext: ".zip", want: "pics.zip"},
+		{name: "random", path: "/tmp/notes.txt", randomize: 12},
+		{name: "code", path: "/tmp/notes.txt", code: true},
+		{name: "folder with a code", path: "/tmp/pics", ext: ".zip", code: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken := map[string]bool{"": true}
+			got, err := itemURI(tt.path, tt.ext, tt.randomize, tt.code, taken)
+			if err != nil {
+				t.Fatalf("itemURI() error = %v", err)
+			}
+			if !taken[got] {
+				t.Errorf("itemURI() did not mark %s taken", got)
+			}
+			switch {
+			case tt.randomize > 0:
+				if len(got) != tt.randomize {
+					t.Errorf("itemURI() = %s, want %d random characters", got, tt.randomize)
+				}
+			case tt.code:
+				if code, ok := parseWordCode(got); !ok || code != got {
+					t.Errorf("itemURI() = %s, want a word code", got)
+				}
+			case got != tt.want:
+				t.Errorf("itemURI() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reachesEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		rng  string
+		size int64
+		want bool
+	}{
+		{name: "no range", rng: "", size: 100, want: true},
+		{name: "ranges ignored", rng: "bytes=0-9", size: -1, want: true},
+		{name: "first part", rng: "bytes=0-9", size: 100, want: false},
+		{name: "last byte", rng: "bytes=99-99", size: 100, want: true},
+		{name: "open range", rng: "bytes=50-", size: 100, want: true},
+		{name: "suffix range", rng: "bytes=-10", size: 100, want: true},
+		{name: "several ranges", rng: "bytes=0-9, 90-99", size: 100, want: true},
+		{name: "other unit", rng: "items=0-9", size: 100, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reachesEnd(tt.rng, tt.size); got != tt.want {
+				t.Errorf("reachesEnd() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_session_download(t *testing.T) {
+	aborted, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		countAborted  bool
+		ctx           context.Context
+		rng           string
+		complete      bool
+		wantRemaining int
+	}{
+		{name: "complete", ctx: context.Background(), complete: true, wantRemaining: 1},
+		{name: "aborted", ctx: aborted, wantRemaining: 2},
+		{name: "aborted counted", countAborted: true, ctx: aborted, wantRemaining: 1},
+		{name: "first part aborted", ctx: aborted, rng: "bytes=0-9", wantRemaining: 2},
+		{name: "first part", ctx: context.Background(), rng: "bytes=0-9", complete: true, wantRemaining: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := &shareItem{uri: "file.txt", size: 100, remaining: 2}
+			s := &session{items: []*shareItem{it}, countAborted: tt.countAborted, done: func() {}}
+
+			r := httptest.NewRequest(http.MethodGet, "/file.txt", nil).WithContext(tt.ctx)
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			s.download(httptest.NewRecorder(), r, it, it.size, func() (bool, error) {
+				return tt.complete, nil
+			})
+			if it.remaining != tt.wantRemaining || it.reserved != 0 {
+				t.Errorf("remaining = %d, reserved = %d, want %d and 0", it.remaining, it.reserved, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+func Test_session_download_id(t *testing.T) {
+	it := &shareItem{uri: "file.txt", size: 100, remaining: 1}
+	done := false
+	s := &session{items: []*shareItem{it}, done: func() { done = true }}
+	get := func(id, rng string, complete bool) int {
+		r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+		r.Header.Set(downloadIDHeader, id)
+		r.Header.Set("Range", rng)
+		w := httptest.NewRecorder()
+		s.download(w, r, it, it.size, func() (bool, error) {
+			return complete, nil
+		})
+		return w.Code
+	}
+
+	// The parts of a download share its slot, which other downloads cannot
+	// take
+	get("a", "bytes=0-49", true)
+	get("a", "bytes=50-98", true)
+	if it.remaining != 1 || it.reserved != 1 || done {
+		t.Fatalf("remaining = %d, reserved = %d, done = %v, want 1, 1 and false", it.remaining, it.reserved, done)
+	}
+	if code := get("b", "bytes=0-98", true); code != http.StatusGone {
+		t.Errorf("status = %d, want %d", code, http.StatusGone)
+	}
+
+	// The download counts once its end is sent
+	get("a", "bytes=99-99", true)
+	if it.remaining != 0 || it.reserved != 0 || !done {
+		t.Errorf("remaining = %d, reserved = %d, done = %v, want 0, 0 and true", it.remaining, it.reserved, done)
+	}
+}
+
+func Test_session_download_failed(t *testing.T) {
+	it := &shareItem{uri: "folder.tar", size: -1, remaining: 1}
+	s := &session{items: []*shareItem{it}, done: func() {}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.download(w, r, it, -1, func() (bool, error) {
+			w.Write([]byte("first part"))
+			w.(http.Flusher).Flush()
+			return false, errors.New("cannot read the folder")
+		})
+	}))
+	defer srv.Close()
+
+	// The client sees a broken transfer, not a complete truncated one
+	resp, err := http.Get(srv.URL + "/folder.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Error("failed download ended cleanly")
+	}
+	if it.remaining != 1 || it.reserved != 0 {
+		t.Errorf("remaining = %d, reserved = %d, want 1 and 0", it.remaining, it.reserved)
+	}
+}
+
+func Test_session_download_reserved(t *testing.T) {
+	it := &shareItem{uri: "file.txt", size: 100, remaining: 1}
+	done := false
+	s := &session{items: []*shareItem{it}, done: func() { done = true }}
+
+	// While a download holds the last slot, other downloads are gone
+	s.download(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/file.txt", nil), it, it.size, func() (bool, error) {
+		w := httptest.NewRecorder()
+		s.download(w, httptest.NewRequest(http.MethodGet, "/file.txt", nil), it, it.size, func() (bool, error) {
+			t.Error("second download served")
+			return true, nil
+		})
+		if w.Code != http.StatusGone {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusGone)
+		}
+		return true, nil
+	})
+
+	if it.remaining != 0 || !done {
+		t.Errorf("remaining = %d, done = %v, want 0 and true", it.remaining, done)
+	}
+}
+
+func Test_session_register(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shaloc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Names that are not valid mux patterns
+	for _, name := range []string{"my file.txt", "{id}.txt", "GET x"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "pics {2021}"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pics {2021}", "a b.txt"), []byte("a b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []*shareItem
+	for _, name := range []string{"my file.txt", "{id}.txt", "GET x", "pics {2021}"} {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, &shareItem{path: filepath.Join(dir, name), uri: filepath.Base(name), folder: fi.IsDir(), size: fi.Size(), remaining: -1})
+	}
+	s := &session{items: items, sums: newChecksumCache(false), format: "zip", browse: true, done: func() {}}
+	mux := http.NewServeMux()
+	if err := s.register(mux); err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/my%20file.txt", want: "my file.txt"},
+		{path: "/%7Bid%7D.txt", want: "{id}.txt"},
+		{path: "/GET%20x", want: "GET x"},
+		{path: "/pics%20%7B2021%7D/a%20b.txt", want: "a b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || string(got) != tt.want {
+				t.Errorf("GET %s = %d %q, want %q", tt.path, resp.StatusCode, got, tt.want)
+			}
+		})
+	}
+
+	resp, err := http.Get(srv.URL + "/my%20file.txt.sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET checksum = %d, want 200", resp.StatusCode)
+	}
+
+	// The URI of the handshakes cannot be shared with --pake
+	clash := []*shareItem{{path: filepath.Join(dir, "my file.txt"), uri: pakePath[1:], remaining: -1}}
+	s = &session{items: clash, sums: newChecksumCache(false), done: func() {}}
+	if err := s.register(http.NewServeMux()); err != nil {
+		t.Errorf("register() error = %v", err)
+	}
+	s = &session{items: clash, pake: newPAKEServer("42-otter", make([]byte, keySize)), sums: newChecksumCache(false), done: func() {}}
+	if err := s.register(http.NewServeMux()); err == nil {
+		t.Errorf("register() shared an item at %s with --pake", pakePath)
+	}
+}
+
+func Test_session_serveIndex(t *testing.T) {
+	s := &session{items: []*shareItem{
+		{uri: "notes.txt", size: 42, remaining: -1},
+		{uri: "sbChTqWQqPOiFqz", size: 42, remaining: -1, unlisted: true},
+		{uri: "7-purple-otter-river", size: 42, remaining: -1, unlisted: true, code: true},
+		{uri: "gone.txt", size: 42, remaining: 0},
+	}}
+
+	for _, accept := range []string{"text/html", "application/json"} {
+		t.Run(accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", accept)
+			w := httptest.NewRecorder()
+			s.serveIndex(w, r)
+
+			body := w.Body.String()
+			if !strings.Contains(body, "notes.txt") {
+				t.Errorf("serveIndex() does not list notes.txt: %s", body)
+			}
+			for _, hidden := range []string{"sbChTqWQqPOiFqz", "7-purple-otter-river", "gone.txt"} {
+				if strings.Contains(body, hidden) {
+					t.Errorf("serveIndex() lists %s", hidden)
+				}
+			}
+		})
+	}
+}
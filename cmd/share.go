@@ -1,25 +1,20 @@
 package cmd
 
 import (
-	"archive/zip"
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/sha256"
-	"encoding/binary"
+	"crypto/tls"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
@@ -27,9 +22,9 @@ import (
 
 // shareCmd represents the share command
 var shareCmd = &cobra.Command{
-	Use:   "share",
-	Short: "Share a file or a folder",
-	Long: `share allow you to start a HTTP server to share a file or a folder. For example:
+	Use:   "share [files and folders...]",
+	Short: "Share files and folders",
+	Long: `share allow you to start a HTTP server to share files and folders. For example:
 
 This will share the file test.txt on all interfaces on port 8080
   shaloc share -f test.txt
@@ -39,141 +34,353 @@ This will share blah.txt on 192.168.1.36:1337:
 
 This will share the folder /home/user/sup3r-f0ld3r on 0.0.0.0:8080:
   shaloc share -F /home/user/sup3r-f0ld3r
+
+This will share the same folder as a tar archive compressed with zstd, keeping
+permissions and symbolic links:
+  shaloc share -F /home/user/sup3r-f0ld3r --format tzst
+
+This will let the recipients browse the folder and pick the files they need:
+  shaloc share -F /home/user/sup3r-f0ld3r --browse
+
+This will share two files and a folder, each at most 3 times, with an index
+listing them on http://0.0.0.0:8080/:
+  shaloc share notes.txt picture.png /home/user/sup3r-f0ld3r -m 3
+
+This will encrypt secret.txt with a key derived using 256 MiB of memory:
+  shaloc share -f secret.txt --aes --kdf-memory 256
+
+This will encrypt secret.txt for the owners of two keys created by 'shaloc keygen':
+  shaloc share -f secret.txt --recipient shaloc1... --recipient team-keys.txt
+
+This will serve file.txt over HTTPS with a self-signed certificate, and print
+its fingerprint for 'shaloc get --fingerprint':
+  shaloc share -f file.txt --tls
+
+This will only serve file.txt to the requests carrying a random token, which
+is added to the printed URL:
+  shaloc share -f file.txt --token
+
+This will only serve file.txt to the clients of the local networks:
+  shaloc share -f file.txt --lan-only
+
+This will stop sharing file.txt after 30 minutes, or after 3 downloads:
+  shaloc share -f file.txt --expire 30m -m 3
+
+This will announce file.txt on the local network, for 'shaloc get file.txt':
+  shaloc share -f file.txt --advertise
+
+This will share file.txt at a word code like 7-purple-otter-river, for
+'shaloc get 7-purple-otter-river' on the local network:
+  shaloc share -f file.txt --code
+
+This will only serve file.txt on the address of the interface eth0:
+  shaloc share -f file.txt --interface eth0
+
+This will print the URL of file.txt as a QR code, to download it from a
+phone on the same network:
+  shaloc share -f file.txt --qr
+
+This will encrypt file.txt with a key given to the receivers that know a
+short code, like 42-otter-river, for 'shaloc get --pake':
+  shaloc share -f file.txt --pake
 `,
 
 	Run: func(cmd *cobra.Command, args []string) {
 		ip, _ := cmd.Flags().GetString("ip")
 		port, _ := cmd.Flags().GetString("port")
-		file, _ := cmd.Flags().GetString("file")
-		folder, _ := cmd.Flags().GetString("folder")
+		files, _ := cmd.Flags().GetStringArray("file")
+		folders, _ := cmd.Flags().GetStringArray("folder")
 		randomize, _ := cmd.Flags().GetInt("random")
 		maxDownloads, _ := cmd.Flags().GetInt("max")
 		useAES, _ := cmd.Flags().GetBool("aes")
+		recipients, _ := cmd.Flags().GetStringArray("recipient")
+		browse, _ := cmd.Flags().GetBool("browse")
+		format, _ := cmd.Flags().GetString("format")
+		useBlake3, _ := cmd.Flags().GetBool("blake3")
+		useTLS, _ := cmd.Flags().GetBool("tls")
+		certFile, _ := cmd.Flags().GetString("cert")
+		keyFile, _ := cmd.Flags().GetString("key")
+		useToken, _ := cmd.Flags().GetBool("token")
+		basicAuth, _ := cmd.Flags().GetString("basic-auth")
+		authLimit, _ := cmd.Flags().GetInt("auth-limit")
+		allow, _ := cmd.Flags().GetStringSlice("allow")
+		deny, _ := cmd.Flags().GetStringSlice("deny")
+		lanOnly, _ := cmd.Flags().GetBool("lan-only")
+		expire, _ := cmd.Flags().GetDuration("expire")
+		until, _ := cmd.Flags().GetString("until")
+		countAborted, _ := cmd.Flags().GetBool("count-aborted")
+		advertise, _ := cmd.Flags().GetBool("advertise")
+		useCode, _ := cmd.Flags().GetBool("code")
+		usePAKE, _ := cmd.Flags().GetBool("pake")
+		useQR, _ := cmd.Flags().GetBool("qr")
+		qrPNG, _ := cmd.Flags().GetString("qr-png")
+		iface, _ := cmd.Flags().GetString("interface")
+
+		// Positional arguments can be files or folders
+		for _, a := range args {
+			isFol, err := isFolder(a)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			if isFol {
+				folders = append(folders, a)
+			} else {
+				files = append(files, a)
+			}
+		}
 
-		var uri string
-
-		if file == "" && folder == "" {
+		if len(files) == 0 && len(folders) == 0 {
 			fmt.Println("You must provide at least a file to share (-f) or a folder (-F) !")
 			os.Exit(1)
-		} else if file != "" && folder != "" {
-			fmt.Println("You cannot provide a file and a folder !")
+		} else if browse && len(folders) == 0 {
+			fmt.Println("You can only browse a folder (-F) !")
+			os.Exit(1)
+		} else if _, ok := archiveExtensions[format]; !ok {
+			fmt.Printf("Unknown archive format %s, use one of %s !\n", format, strings.Join(archiveFormats(), ", "))
+			os.Exit(1)
+		} else if useAES && len(recipients) > 0 {
+			fmt.Println("You cannot use a passphrase (--aes) and recipients (--recipient) at the same time !")
+			os.Exit(1)
+		} else if maxDownloads == 0 {
+			fmt.Println("The maximum number of downloads (-m) must be positive !")
+			os.Exit(1)
+		} else if usePAKE && (useAES || len(recipients) > 0) {
+			fmt.Println("You cannot use a code (--pake) with a passphrase (--aes) or recipients (--recipient) !")
+			os.Exit(1)
+		} else if iface != "" && cmd.Flags().Changed("ip") {
+			fmt.Println("You cannot use an IP address (-i) and an interface (--interface) at the same time !")
+			os.Exit(1)
+		} else if randomize > 0 && useCode {
+			fmt.Println("You cannot use a random URI (-r) and a word code (--code) at the same time !")
 			os.Exit(1)
 		}
 
-		// If the folder flag is provided...
-		if folder != "" {
-			// ...be sure to serve a folder
-			isFol, err := isFolder(folder)
-			if err != nil {
-				logrus.Errorf("%s", err)
-				return
-			}
-
-			// Zip it
-			if isFol {
-				// If the user provided a full path, we want to keep only the filename.
-				parts := strings.Split(folder, "/")
-				if parts[len(parts)-1] == "" {
-					// If the path ends with /, the last item of parts will be an
-					// empty string
-					uri = parts[len(parts)-2] + ".zip"
-				} else {
-					uri = parts[len(parts)-1] + ".zip"
-				}
-
-				file, err = compressFolder(folder)
-				if err != nil {
-					logrus.Errorf("%s", err)
-					return
-				}
-			}
-		} else {
-			// Check if the file provided is really a file
-			isFol, err := isFolder(file)
-			if err != nil {
-				logrus.Errorf("%s", err)
-				return
-			}
-			// If not, log an error and exit
-			if isFol {
-				logrus.Errorf("%s", fmt.Errorf("%s is not a file", file))
-				os.Exit(1)
-			}
-			// If the user provided a full path, we want to keep only the filename.
-			parts := strings.Split(file, "/")
-			uri = parts[len(parts)-1]
+		// With --expire or --until, the share ends at a deadline, or earlier
+		// if the maximum number of downloads is reached first
+		deadline, err := shareDeadline(expire, until, time.Now())
+		if err != nil {
+			logrus.Fatalf("%s", err)
 		}
 
-		// If the flag -r is provided, randomize the URI
+		// If the flag -r is provided, randomize the URIs
 		if randomize > 0 {
 			rand.Seed(time.Now().UnixNano())
-			uri = randID(randomize)
 		}
 
-		// If the flag --aes is provided, ask for a passphrase
-		if useAES {
-			fmt.Print("Type encryption key:\n")
-			bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+		// Every item is served at its own URI: its base name, with the
+		// extension of the archive format for folders.
+		var items []*shareItem
+		taken := map[string]bool{"": true}
+		for _, name := range append(files, folders...) {
+			folder := len(items) >= len(files)
+
+			var ext string
+			if folder && !browse {
+				ext = archiveExtensions[format]
+			}
+			uri, err := itemURI(name, ext, randomize, useCode, taken)
 			if err != nil {
 				logrus.Fatalf("%s", err)
 			}
 
-			file, err = encryptFile(string(bytePassword), file)
+			it, err := newShareItem(name, uri, folder, maxDownloads)
 			if err != nil {
-				log.Fatalf("%s", err)
+				logrus.Fatalf("%s", err)
 			}
+			it.unlisted = randomize > 0 || useCode
+			it.code = useCode
+			items = append(items, it)
 		}
 
-		srv := &http.Server{
-			Addr: ip + ":" + port,
+		// The key is derived once, and the files are encrypted on the fly for
+		// each download.
+		enc, err := encryptorFromFlags(cmd)
+		if err != nil {
+			logrus.Fatalf("%s", err)
+		}
+
+		// With --pake, the content is encrypted with a random key, given to
+		// the receivers that know the code
+		var pake *pakeServer
+		var pakeCode string
+		if usePAKE {
+			var key []byte
+			if enc, key, err = newPAKEEncryptor(); err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			if pakeCode, err = newWordCode(pakeCodeWords); err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			pake = newPAKEServer(pakeCode, key)
 		}
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		http.HandleFunc("/"+uri, func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Disposition", "attachment; filename="+file)
-			w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		sess := &session{items: items, enc: enc, pake: pake, sums: newChecksumCache(useBlake3), format: format, browse: browse, countAborted: countAborted, done: cancel}
+		mux := http.NewServeMux()
+		if err := sess.register(mux); err != nil {
+			logrus.Fatalf("%s", err)
+		}
 
-			openfile, err := os.Open(file)
+		// With --interface, serve on the address of this interface
+		if iface != "" {
+			if ip, err = interfaceIP(iface); err != nil {
+				logrus.Fatalf("%s", err)
+			}
+		}
+
+		srv := &http.Server{
+			Addr:    net.JoinHostPort(ip, port),
+			Handler: mux,
+		}
+
+		// With --token or --basic-auth, only serve the authenticated requests.
+		// The token is added to the printed URLs.
+		var query string
+		if useToken || basicAuth != "" {
+			var token string
+			if useToken {
+				if token, err = newToken(); err != nil {
+					logrus.Fatalf("%s", err)
+				}
+				query = "?" + tokenParam + "=" + token
+			}
+			auth, err := newAuthenticator(token, basicAuth, authLimit)
 			if err != nil {
-				fmt.Println(err)
-				return
+				logrus.Fatalf("%s", err)
 			}
+			srv.Handler = auth.wrap(mux)
+		}
+
+		// With --allow, --deny or --lan-only, only serve the permitted
+		// clients, before they can even try to authenticate
+		if len(allow) > 0 || len(deny) > 0 || lanOnly {
+			access, err := newAccessList(allow, deny, lanOnly)
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			srv.Handler = access.wrap(srv.Handler)
+		}
 
-			_, err = io.Copy(w, openfile)
+		// With --tls, --cert or --key, serve over HTTPS, with a self-signed
+		// certificate if none is provided
+		scheme := "http"
+		if useTLS || certFile != "" || keyFile != "" {
+			cert, err := loadCertificate(certFile, keyFile, ip)
 			if err != nil {
-				logrus.Errorf("%s", err)
+				logrus.Fatalf("%s", err)
+			}
+			srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+			scheme = "https"
+			fmt.Printf("TLS certificate fingerprint (SHA-256): %s\n", certFingerprint(cert))
+		}
 
+		// The URLs are printed with the addresses the clients can reach,
+		// rather than with 0.0.0.0. With --qr or --qr-png, they are also
+		// given as QR codes, with the primary address.
+		hosts := serverAddresses(ip)
+		pngTaken := map[string]bool{}
+
+		for _, it := range items {
+			p := it.urlPath(browse) + query
+			switch {
+			case it.folder && browse:
+				printURLs(os.Stdout, "Browsing "+it.path, hosts, scheme, port, p)
+			case it.folder:
+				printURLs(os.Stdout, "Sharing "+it.path+" as "+filepath.Base(it.path)+archiveExtensions[format], hosts, scheme, port, p)
+			default:
+				printURLs(os.Stdout, "Sharing "+it.path, hosts, scheme, port, p)
+			}
+			if it.code {
+				fmt.Printf("Download it on the local network with: shaloc get %s\n", it.uri)
 			}
 
-			if maxDownloads >= 0 {
-				maxDownloads--
-				if maxDownloads == 0 {
-					if err := os.Remove(file); err != nil {
-						logrus.Fatalf("%s", err)
-					}
-					cancel()
+			if !useQR && qrPNG == "" {
+				continue
+			}
+			u := hosts[0].url(scheme, port, p)
+			if useQR {
+				if err := printQR(os.Stdout, u); err != nil {
+					logrus.Fatalf("%s", err)
+				}
+			}
+			if qrPNG != "" {
+				name := uniqueURI(qrPNG, pngTaken)
+				if err := writeQRPNG(u, name); err != nil {
+					logrus.Fatalf("%s", err)
 				}
-				logrus.Infof("Downloads remaining: %d", maxDownloads)
+				fmt.Printf("QR code of %s written in %s\n", u, name)
+			}
+		}
+		if len(items) > 1 {
+			printURLs(os.Stdout, "Index of the shared items", hosts, scheme, port, "/"+query)
+		}
+		if pake != nil {
+			fmt.Printf("Code to give to the receivers, for 'shaloc get --pake': %s\n", pakeCode)
+		}
+
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			expired = timer.C
+
+			fmt.Printf("Sharing until %s (%s)", deadline.Format("2006-01-02 15:04:05"), time.Until(deadline).Round(time.Second))
+			if maxDownloads > 0 {
+				fmt.Printf(", or until each item is downloaded %d time(s)", maxDownloads)
 			}
-		})
+			fmt.Println(".")
+		}
 
-		fmt.Printf("Sharing %s on http://%s:%s/%s\n", file, ip, port, uri)
 		go func() {
-			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
 				logrus.Warnf("%s", err)
 			}
 		}()
 
+		// With --advertise or --code, announce the items on the local network
+		if advertise || useCode {
+			p, err := strconv.Atoi(port)
+			if err != nil {
+				logrus.Fatalf("invalid port %s", port)
+			}
+			stop, err := sess.advertise(p, srv.TLSConfig != nil, useToken || basicAuth != "")
+			if err != nil {
+				logrus.Fatalf("%s", err)
+			}
+			defer stop()
+			fmt.Println("Advertising the shared items on the local network.")
+		}
+
+		var locked <-chan struct{}
+		if pake != nil {
+			locked = pake.locked
+		}
+
+		reason := "Max number of downloads reached"
 		select {
 		case <-ctx.Done():
-			// Shutdown the server when the context is canceled
-			if err := srv.Shutdown(ctx); err != nil {
-				logrus.Errorf("%s", err)
-			}
+		case <-expired:
+			reason = "Share expired"
+		case <-locked:
+			reason = "Too many wrong codes"
 		}
-		logrus.Infof("Max number of downloads reached, shutting down the server.")
+
+		// Shutdown the server when the context is canceled or the share
+		// expires, leaving some time to the last responses to be sent
+		shutdownCtx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+		defer stop()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logrus.Errorf("%s", err)
+		}
+		logrus.Infof("%s, shutting down the server.", reason)
 
 	},
 }
@@ -182,11 +389,88 @@ func init() {
 	rootCmd.AddCommand(shareCmd)
 	shareCmd.Flags().StringP("ip", "i", "0.0.0.0", "IP address to serve on.")
 	shareCmd.Flags().StringP("port", "p", "8080", "Port to serve on.")
-	shareCmd.Flags().StringP("file", "f", "", "File to share.")
-	shareCmd.Flags().StringP("folder", "F", "", "Folder to share. It will be zipped.")
+	shareCmd.Flags().String("interface", "", "Network interface to serve on, like eth0, instead of an IP address.")
+	shareCmd.Flags().StringArrayP("file", "f", nil, "File to share. Can be repeated.")
+	shareCmd.Flags().StringArrayP("folder", "F", nil, "Folder to share. It will be archived on the fly. Can be repeated.")
+	shareCmd.Flags().String("format", "zip", "Archive format of shared folders: "+strings.Join(archiveFormats(), ", ")+".")
+	shareCmd.Flags().Bool("browse", false, "Serve the folders as browsable trees instead of archives.")
 	shareCmd.Flags().IntP("random", "r", 0, "Randomize the URI. The integer provided is the random string lentgh.")
-	shareCmd.Flags().IntP("max", "m", -1, "Maximum number of downloads.")
-	shareCmd.Flags().Bool("aes", false, "Encrypt file with AES-256.")
+	shareCmd.Flags().Bool("code", false, "Use a word code, like 7-purple-otter-river, as the URI, and announce it on the local network.")
+	shareCmd.Flags().IntP("max", "m", -1, "Maximum number of downloads of each shared item.")
+	shareCmd.Flags().Bool("count-aborted", false, "Count the downloads aborted by the clients against the maximum, instead of releasing their slot.")
+	shareCmd.Flags().Bool("blake3", false, "Publish the BLAKE3 checksums of the shared content, besides SHA-256.")
+	shareCmd.Flags().Bool("tls", false, "Serve over HTTPS, with a self-signed certificate unless --cert and --key are provided.")
+	shareCmd.Flags().String("cert", "", "PEM encoded TLS certificate to serve with. Implies --tls.")
+	shareCmd.Flags().String("key", "", "PEM encoded private key of the TLS certificate.")
+	shareCmd.Flags().Bool("token", false, "Require a random token, given in the URL or as a bearer token.")
+	shareCmd.Flags().String("basic-auth", "", "Require these credentials, in the user:pass form, with HTTP basic authentication.")
+	shareCmd.Flags().Int("auth-limit", 0, "Block for a minute the clients failing to authenticate this many times in a minute. 0 means no limit.")
+	shareCmd.Flags().StringSlice("allow", nil, "Only serve the clients in these ranges, in the CIDR notation. Can be repeated.")
+	shareCmd.Flags().StringSlice("deny", nil, "Never serve the clients in these ranges, in the CIDR notation. Can be repeated.")
+	shareCmd.Flags().Bool("lan-only", false, "Only serve the clients of the private, link-local and loopback ranges.")
+	shareCmd.Flags().Duration("expire", 0, "Stop sharing after this duration, like 30m or 2h.")
+	shareCmd.Flags().String("until", "", "Stop sharing at this time, like '2006-01-02 15:04' or '15:04'.")
+	shareCmd.Flags().Bool("qr", false, "Print the URLs as QR codes, with the primary address if serving on all of them.")
+	shareCmd.Flags().String("qr-png", "", "Write the URLs as QR codes in this PNG file, numbered if several items are shared.")
+	shareCmd.Flags().Bool("advertise", false, "Announce the shared items on the local network with mDNS, for 'shaloc discover'.")
+	addEncryptionFlags(shareCmd)
+	shareCmd.Flags().Bool("pake", false, "Encrypt the content with a random key, given to the receivers that know a short code with a PAKE handshake.")
+}
+
+// addEncryptionFlags adds the flags read by encryptorFromFlags to cmd.
+func addEncryptionFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("aes", false, "Encrypt file with AES-256.")
+	cmd.Flags().String("kdf", defaultKDF, "Key derivation function used with --aes: argon2id or scrypt.")
+	cmd.Flags().Uint32("kdf-time", defaultKDFTime, "Number of passes of argon2id.")
+	cmd.Flags().Uint32("kdf-memory", defaultKDFMemory, "Memory used by the key derivation, in MiB.")
+	cmd.Flags().Uint8("kdf-threads", defaultKDFThreads, "Number of threads used by the key derivation.")
+	cmd.Flags().StringArray("recipient", nil, "Public key, or file of public keys, to encrypt the file to. Can be repeated.")
+}
+
+// encryptorFromFlags returns the encryptor requested by the flags of cmd, or
+// nil if the content must not be encrypted. With --aes, it asks for a
+// passphrase.
+func encryptorFromFlags(cmd *cobra.Command) (*encryptor, error) {
+	useAES, _ := cmd.Flags().GetBool("aes")
+	kdf, _ := cmd.Flags().GetString("kdf")
+	kdfTime, _ := cmd.Flags().GetUint32("kdf-time")
+	kdfMemory, _ := cmd.Flags().GetUint32("kdf-memory")
+	kdfThreads, _ := cmd.Flags().GetUint8("kdf-threads")
+	recipients, _ := cmd.Flags().GetStringArray("recipient")
+
+	if useAES && len(recipients) > 0 {
+		return nil, fmt.Errorf("cannot use a passphrase (--aes) and recipients (--recipient) at the same time")
+	}
+
+	// If the flag --aes is provided, ask for a passphrase
+	if useAES {
+		k, err := newKDFParams(kdf, kdfTime, kdfMemory, kdfThreads)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Print("Type encryption key:\n")
+		bytePassword, err := terminal.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return nil, err
+		}
+		return newEncryptor(bytePassword, k)
+	}
+
+	// If recipients are provided, encrypt the file to their public keys
+	if len(recipients) > 0 {
+		var keys [][32]byte
+		for _, r := range recipients {
+			k, err := parseRecipients(r)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k...)
+		}
+		return newRecipientsEncryptor(keys)
+	}
+
+	return nil, nil
 }
 
 // ifFolder returns true if name is a folder, false elsewhere.
@@ -213,134 +497,105 @@ func randID(n int) string {
 	return string(b)
 }
 
-// compressFolder compresses recursively source, and returns the path of the compressed file
-func compressFolder(source string) (string, error) {
-	// Create a temporary file prefixed with shaloc
-	of, err := ioutil.TempFile("", "shaloc")
+// serveFile writes the content of f to w, encrypting it with enc if it is not
+// nil. Plain files honour Range and conditional requests, and are described by
+// an ETag, a Last-Modified date and their checksums so that downloads can be
+// resumed and verified. Encrypted files are authenticated instead. It reports
+// whether the end of the file was sent, which is what counts as a download.
+func serveFile(w http.ResponseWriter, r *http.Request, f *os.File, enc *encryptor, sums *checksumCache) (bool, error) {
+	fi, err := f.Stat()
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
-	defer of.Close()
-
-	logrus.Infof("Zipping %s into %s...", source, of.Name())
-
-	// Init and start the spinner
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Start()
 
-	archive := zip.NewWriter(of)
-	defer archive.Close()
-
-	info, err := os.Stat(source)
-	if err != nil {
-		return "", nil
+	if enc == nil {
+		if err := sums.setHeaders(w, f.Name(), fi); err != nil {
+			return false, err
+		}
+		w.Header().Set("ETag", fileETag(fi))
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		http.ServeContent(rw, r, "", fi.ModTime(), f)
+		return r.Method != http.MethodHead && sentAll(rw), nil
 	}
 
-	var baseDir string
-	if info.IsDir() {
-		baseDir = filepath.Base(source)
+	// A new nonce is used for each download, so the encrypted content is
+	// never the same twice and cannot be requested in parts.
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.FormatInt(enc.encryptedSize(fi.Size()), 10))
+	if r.Method == http.MethodHead {
+		return false, nil
 	}
-
-	if err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
-		}
-
-		if baseDir != "" {
-			header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, source))
-		}
-
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
-
-		writer, err := archive.CreateHeader(header)
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		_, err = io.Copy(writer, file)
-		return err
-	}); err != nil {
-		log.Fatalf("%s", err)
+	ew, err := enc.newWriter(w)
+	if err != nil {
+		return false, err
 	}
+	if _, err := io.Copy(ew, f); err != nil {
+		return false, err
+	}
+	return true, ew.Close()
+}
 
-	s.Stop()
-
-	return of.Name(), err
+// fileETag returns a strong ETag that changes whenever the file is modified.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
 }
 
-func encryptFile(p, filename string) (string, error) {
-	// Create a temporary file prefixed with shaloc
-	of, err := ioutil.TempFile("", "shaloc")
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer of.Close()
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
 
-	key := sha256.Sum256([]byte(p))
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
 
-	plaintext, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return "", err
-	}
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+	sw.written += int64(n)
+	return n, err
+}
 
-	// Write the original plaintext size into the output file first, encoded in
-	// a 8-byte integer.
-	origSize := uint64(len(plaintext))
-	if err = binary.Write(of, binary.LittleEndian, origSize); err != nil {
-		return "", err
+// sentAll reports whether the response recorded by sw was written entirely,
+// which is not the case when the client aborts the download, and holds
+// content: the whole file, or the requested ranges of it.
+func sentAll(sw *statusWriter) bool {
+	if n, err := strconv.ParseInt(sw.Header().Get("Content-Length"), 10, 64); err != nil || sw.written != n {
+		return false
 	}
+	return sw.status == http.StatusOK || sw.status == http.StatusPartialContent
+}
 
-	// Pad plaintext to a multiple of BlockSize with random padding.
-	if len(plaintext)%aes.BlockSize != 0 {
-		bytesToPad := aes.BlockSize - (len(plaintext) % aes.BlockSize)
-		padding := make([]byte, bytesToPad)
-		if _, err := rand.Read(padding); err != nil {
-			return "", err
+// serveStream writes what write produces to w, encrypting it with enc if it is
+// not nil. The checksums of plain content are sent as trailers.
+func serveStream(w http.ResponseWriter, enc *encryptor, sums *checksumCache, write func(io.Writer) error) error {
+	if enc == nil {
+		hw := sums.declareTrailers(w)
+		if err := write(io.MultiWriter(w, hw)); err != nil {
+			return err
 		}
-		plaintext = append(plaintext, padding...)
+		setTrailers(w, hw)
+		return nil
 	}
+	return encryptTo(w, enc, write)
+}
 
-	// Generate random IV and write it to the output file.
-	iv := make([]byte, aes.BlockSize)
-	if _, err := rand.Read(iv); err != nil {
-		return "", err
+// encryptTo writes what write produces to w, encrypting it with enc if it is
+// not nil.
+func encryptTo(w io.Writer, enc *encryptor, write func(io.Writer) error) error {
+	if enc == nil {
+		return write(w)
 	}
-	if _, err = of.Write(iv); err != nil {
-		return "", err
-	}
-
-	// Ciphertext has the same size as the padded plaintext.
-	ciphertext := make([]byte, len(plaintext))
 
-	// Use AES implementation of the cipher.Block interface to encrypt the whole
-	// file in CBC mode.
-	block, err := aes.NewCipher(key[:])
+	ew, err := enc.newWriter(w)
 	if err != nil {
-		return "", err
+		return err
 	}
-	mode := cipher.NewCBCEncrypter(block, iv)
-	mode.CryptBlocks(ciphertext, plaintext)
-
-	if _, err = of.Write(ciphertext); err != nil {
-		return "", err
+	if err := write(ew); err != nil {
+		return err
 	}
-	return of.Name(), nil
+	return ew.Close()
 }
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is the validity of the generated certificates. They are
+// pinned by their fingerprint, so it only bounds how long a leaked key is
+// useful.
+const selfSignedValidity = 30 * 24 * time.Hour
+
+// loadCertificate loads the certificate and the key from certFile and keyFile,
+// or generates a self-signed certificate for ip if they are empty.
+func loadCertificate(certFile, keyFile, ip string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return tls.Certificate{}, fmt.Errorf("both a certificate (--cert) and a key (--key) are needed")
+		}
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	return selfSignedCertificate(ip)
+}
+
+// selfSignedCertificate generates an ephemeral ECDSA certificate, valid for ip
+// and localhost.
+func selfSignedCertificate(ip string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "shaloc"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if addr := net.ParseIP(ip); addr != nil && !addr.IsUnspecified() {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, addr)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFingerprint returns the hex encoded SHA-256 of the DER encoding of the
+// leaf certificate of cert.
+func certFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// parseFingerprint decodes a hex encoded SHA-256 fingerprint. Colons and
+// spaces are allowed between the bytes, as printed by openssl.
+func parseFingerprint(s string) ([]byte, error) {
+	s = strings.NewReplacer(":", "", " ", "").Replace(s)
+	fp, err := hex.DecodeString(s)
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("invalid fingerprint %s, expected the hex encoded SHA-256 of the certificate", s)
+	}
+	return fp, nil
+}
+
+// pinnedClient returns an HTTP client trusting only the server certificate
+// whose SHA-256 fingerprint is fp, instead of the certificate authorities.
+func pinnedClient(fp []byte) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		// The chain and the host name are not verified: the fingerprint
+		// identifies the server by itself.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("the server sent no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fp) {
+				return &fingerprintError{got: hex.EncodeToString(sum[:])}
+			}
+			return nil
+		},
+	}
+	return &http.Client{Transport: transport}
+}
+
+// fingerprintError is returned when the certificate of the server does not
+// match the pinned fingerprint.
+type fingerprintError struct {
+	got string
+}
+
+func (e *fingerprintError) Error() string {
+	return fmt.Sprintf("certificate fingerprint mismatch: got %s", e.got)
+}
+
+// isCertificateError reports whether err is due to an untrusted certificate,
+// which retrying cannot fix.
+func isCertificateError(err error) bool {
+	var fpErr *fingerprintError
+	var verifErr *tls.CertificateVerificationError
+	return errors.As(err, &fpErr) || errors.As(err, &verifErr)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_parseFingerprint(t *testing.T) {
+	sum := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "hex", in: sum},
+		{name: "upper case", in: strings.ToUpper(sum)},
+		{name: "colons", in: strings.TrimSuffix(strings.Repeat("AB:", 32), ":")},
+		{name: "too short", in: "abcd", wantErr: true},
+		{name: "not hex", in: strings.Repeat("zz", 32), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFingerprint(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFingerprint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && hex.EncodeToString(got) != sum {
+				t.Errorf("parseFingerprint() = %x, want %s", got, sum)
+			}
+		})
+	}
+}
+
+func Test_pinnedClient(t *testing.T) {
+	cert, err := selfSignedCertificate("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	other, err := selfSignedCertificate("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    tls.Certificate
+		wantErr bool
+	}{
+		{name: "pinned", cert: cert},
+		{name: "other certificate", cert: other, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp, err := parseFingerprint(certFingerprint(tt.cert))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := pinnedClient(fp).Get(srv.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		})
+	}
+}
@@ -137,7 +137,7 @@ func getLatest(r releases) error {
 		if r[0].Assets[archNum].Name == fullName {
 
 			logrus.Infof("Downloading shaloc:latest (%s)", r[0].TagName)
-			if err := download(binPath+"-tmp", "https://github.com/eze-kiel/shaloc/releases/download/"+r[0].TagName+"/"+fullName); err != nil {
+			if _, err := download(binPath+"-tmp", "https://github.com/eze-kiel/shaloc/releases/download/"+r[0].TagName+"/"+fullName, nil, 1); err != nil {
 				return err
 			}
 
@@ -198,7 +198,7 @@ func getSpecifiedVersion(r releases, version string) error {
 	versionsList := getVersionsList(r)
 	if stringInSlice(version, versionsList) {
 		logrus.Infof("Downloading shaloc:%s...", version)
-		if err := download(binPath+"-tmp", "https://github.com/eze-kiel/shaloc/releases/download/"+version+"/"+fullName); err != nil {
+		if _, err := download(binPath+"-tmp", "https://github.com/eze-kiel/shaloc/releases/download/"+version+"/"+fullName, nil, 1); err != nil {
 			return err
 		}
 
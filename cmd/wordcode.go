@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// codeWords are the words of the word codes: short, common and easy to spell
+// nouns, 8 bits of entropy each.
+var codeWords = []string{
+	"acid", "acorn", "actor", "adobe", "agent", "alarm", "album",
+	"alley", "amber", "anchor", "angle", "ankle", "apple", "apron",
+	"arena", "armor", "arrow", "aspen", "atlas", "attic", "autumn",
+	"badge", "bagel", "baker", "bamboo", "banjo", "barley", "barrel",
+	"basil", "basket", "beach", "beacon", "beard", "beetle", "bell",
+	"berry", "bison", "blade", "blanket", "board", "bonus", "book",
+	"border", "bottle", "boulder", "bounty", "brass", "bread", "breeze",
+	"brick", "bridge", "brook", "broom", "bubble", "bucket", "bugle",
+	"cabin", "cactus", "camel", "camera", "canal", "candle", "canoe",
+	"canyon", "carbon", "cargo", "carrot", "castle", "cedar", "cellar",
+	"cereal", "chalk", "cherry", "chess", "cider", "circus", "citrus",
+	"clock", "cloud", "clover", "cobalt", "cocoa", "comet", "copper",
+	"coral", "cotton", "cougar", "crane", "crater", "crayon", "crystal",
+	"dahlia", "daisy", "dancer", "delta", "desert", "diamond", "dingo",
+	"dolphin", "donkey", "dragon", "drum", "eagle", "easel", "echo",
+	"eclipse", "elbow", "ember", "engine", "falcon", "feather", "fern",
+	"ferry", "fiddle", "field", "finch", "flame", "flute", "forest",
+	"fossil", "fox", "galaxy", "garden", "garlic", "gecko", "ginger",
+	"glacier", "globe", "goose", "granite", "grape", "gravel", "guitar",
+	"hammer", "harbor", "harp", "hazel", "helmet", "heron", "hockey",
+	"honey", "husky", "igloo", "island", "ivory", "jacket", "jaguar",
+	"jelly", "jungle", "kayak", "kettle", "kiwi", "koala", "ladder",
+	"lagoon", "lantern", "lemon", "lily", "linen", "lizard", "lobster",
+	"locket", "lotus", "magnet", "mango", "maple", "marble", "meadow",
+	"melon", "meteor", "mirror", "mitten", "monkey", "moose", "muffin",
+	"napkin", "nectar", "needle", "nickel", "noodle", "nutmeg", "oasis",
+	"ocean", "olive", "onion", "orbit", "orchid", "otter", "owl",
+	"paddle", "panda", "parrot", "peach", "pebble", "pepper", "piano",
+	"pickle", "pigeon", "pillow", "pilot", "planet", "plum", "pocket",
+	"pony", "poppy", "prairie", "puffin", "purple", "quartz", "quill",
+	"rabbit", "radar", "radish", "raven", "ribbon", "river", "robin",
+	"rocket", "saddle", "salmon", "sandal", "satin", "shadow", "shell",
+	"silver", "sketch", "sparrow", "spider", "spruce", "squid", "statue",
+	"summit", "sunset", "swan", "tango", "teapot", "thistle", "thunder",
+	"tiger", "timber", "tomato", "topaz", "trumpet", "tulip", "tundra",
+	"turtle", "valley", "velvet", "violet", "walnut", "walrus", "willow",
+	"window", "wizard", "yacht", "zebra",
+}
+
+// codeWordCount is the number of words of the word codes used as URIs, after
+// their number.
+const codeWordCount = 3
+
+// newWordCode returns a random code like 7-purple-otter-river, easier to
+// dictate than a random string: a number below 100 followed by n words.
+func newWordCode(n int) (string, error) {
+	num, err := rand.Int(rand.Reader, big.NewInt(99))
+	if err != nil {
+		return "", err
+	}
+	parts := []string{strconv.FormatInt(num.Int64()+1, 10)}
+	for i := 0; i < n; i++ {
+		w, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeWords))))
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, codeWords[w.Int64()])
+	}
+	return strings.Join(parts, "-"), nil
+}
+
+// parseWordCode normalizes a word code typed by a user, who may use spaces
+// and capital letters, and reports whether it is one.
+func parseWordCode(s string) (string, bool) {
+	parts := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == '-' || r == ' '
+	})
+	if len(parts) < 3 {
+		return "", false
+	}
+	if n, err := strconv.Atoi(parts[0]); err != nil || n < 1 || n > 99 {
+		return "", false
+	}
+	for _, p := range parts[1:] {
+		if !isCodeWord(p) {
+			return "", false
+		}
+	}
+	return strings.Join(parts, "-"), true
+}
+
+func isCodeWord(w string) bool {
+	for _, c := range codeWords {
+		if c == w {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import "testing"
+
+func Test_parseWordCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		want   string
+		wantOk bool
+	}{
+		{name: "code", s: "7-purple-otter-river", want: "7-purple-otter-river", wantOk: true},
+		{name: "spaces and capitals", s: "7 Purple  otter River", want: "7-purple-otter-river", wantOk: true},
+		{name: "file name", s: "notes.txt"},
+		{name: "too short", s: "7-otter"},
+		{name: "number out of range", s: "100-purple-otter-river"},
+		{name: "no number", s: "purple-otter-river-otter"},
+		{name: "unknown word", s: "7-purple-otter-shaloc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseWordCode(tt.s)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("parseWordCode() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_newWordCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := newWordCode(codeWordCount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := parseWordCode(code); !ok || got != code {
+			t.Fatalf("parseWordCode(%q) = %q, %v", code, got, ok)
+		}
+	}
+}